@@ -12,6 +12,7 @@ import (
 	"errors"
 	"fmt"
 	"regexp"
+	"time"
 )
 
 var ipv4Regex = regexp.MustCompile(`^((25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])\.){3}(25[0-5]|2[0-4][0-9]|1[0-9][0-9]|[1-9]?[0-9])$`)
@@ -27,6 +28,13 @@ type RestConf struct {
 	PostMaxMemory  uint
 	TlsCfg         *tls.Config
 	MaxConnections int
+	// 签名和加密 cookie 的密钥, 第一个用于写入, 全部用于读取, 轮换时把新密钥插到最前面
+	CookieKeys [][]byte
+	// 可信代理的 IP 或 CIDR, 只有来自这些地址的转发头才会被使用, 见 X.GetRemoteIP
+	TrustedProxies []string `json:"trusted_proxies,omitempty"`
+	// 可信代理写入的转发头, X-Forwarded-For (默认), Forwarded 或 X-Real-IP, 其它转发头被忽略
+	// 代理只追加其中一种时, 客户端伪造的另一种不会生效
+	ProxyHeader string `json:"proxy_header,omitempty"`
 }
 
 func (c *RestConf) Url() string {
@@ -73,8 +81,109 @@ func WithLoggerLevel(level string) func(*RestConf) {
 	}
 }
 
+// WithCookieKeys 设置 cookie 密钥, 见 X.SetSignedCookie 和 X.SetSecureCookie
+func WithCookieKeys(keys ...[]byte) func(*RestConf) {
+	return func(c *RestConf) {
+		c.CookieKeys = keys
+	}
+}
+
+// WithTrustedProxies 设置可信代理, 如 WithTrustedProxies("10.0.0.0/8", "127.0.0.1")
+func WithTrustedProxies(proxies ...string) func(*RestConf) {
+	return func(c *RestConf) {
+		c.TrustedProxies = proxies
+	}
+}
+
+// WithProxyHeader 设置可信代理使用的转发头, 如 WithProxyHeader("Forwarded")
+func WithProxyHeader(header string) func(*RestConf) {
+	return func(c *RestConf) {
+		c.ProxyHeader = header
+	}
+}
+
 func WithPrettyLog() func(*RestConf) {
 	return func(c *RestConf) {
 		c.PrettyLog = true
 	}
 }
+
+// RouterConf 路由行为配置, 在 NewRouter 或 Router.Config 时设置
+type RouterConf struct {
+	// 自动响应 OPTIONS 请求, 返回 204 和 Allow 头
+	AutoOptions bool
+	// 末尾 / 与注册时不一致时重定向到注册的形式
+	RedirectTrailingSlash bool
+	// 重定向到清理后的路径, 如 //api/../users => /users
+	CleanPath bool
+	// 大小写不敏感匹配, 重定向到注册时的大小写
+	CaseInsensitive bool
+	// 从请求中读取 API 版本, 为 nil 时总是使用最新版本, 见 Version
+	Versioning *Versioning
+	// 严格模式, 注册冲突的路由时 panic(ErrRouteConflict) 而不是输出警告
+	// 包括重复注册, 同级参数名或通配符名不一致, 有歧义的同级参数, 路由名称重复
+	Strict bool
+	// 请求超时时间, 为 0 时不限制, 单个路由可以通过 Timeout 覆盖
+	// 超时后 x.Context() 被取消, 处理链不再继续, 由错误处理函数返回 TimeoutCode
+	Timeout time.Duration
+	// 超时的状态码, 为 0 时使用 504, 也可以设置为 503
+	TimeoutCode int
+	// 处理结果的编码器, 见 WithRenderer 和 X.Render
+	Renderers []Renderer
+}
+
+func (c *RouterConf) timeoutErr() error {
+	if c.TimeoutCode == 0 || c.TimeoutCode == ErrTimeout.Code {
+		return ErrTimeout
+	}
+	return ErrTimeout.WithArgs().WithCode(c.TimeoutCode)
+}
+
+func WithAutoOptions() func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.AutoOptions = true
+	}
+}
+
+func WithRedirectTrailingSlash() func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.RedirectTrailingSlash = true
+	}
+}
+
+func WithCleanPath() func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.CleanPath = true
+	}
+}
+
+func WithCaseInsensitive() func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.CaseInsensitive = true
+	}
+}
+
+func WithStrict() func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.Strict = true
+	}
+}
+
+// WithTimeout 设置请求超时时间, code 为超时的状态码, 默认 504
+func WithTimeout(d time.Duration, code ...int) func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.Timeout = d
+		if len(code) > 0 {
+			c.TimeoutCode = code[0]
+		}
+	}
+}
+
+func WithVersioning(v Versioning) func(*RouterConf) {
+	return func(c *RouterConf) {
+		if v.Header == "" {
+			v.Header = "Accept-Version"
+		}
+		c.Versioning = &v
+	}
+}
@@ -12,14 +12,23 @@ import (
 	"strconv"
 
 	"github.com/vyes-ai/vigo"
+	"github.com/vyes-ai/vigo/logv"
 )
 
+// JsonResponse 输出处理结果, *vigo.Result 使用其状态码和响应头, 其它为 200
 func JsonResponse(x *vigo.X, data any) error {
-	x.WriteHeader(200)
+	if _, ok := data.(*vigo.Result); !ok {
+		x.WriteHeader(200)
+	}
 	return x.JSON(data)
 }
 
 func JsonErrorResponse(x *vigo.X, err error) error {
+	if x.Written() {
+		// 响应已经开始发送, 无法再输出错误信息
+		logv.Warn().Msgf("error after response written (%d): %v", x.Status(), err)
+		return nil
+	}
 	code := 400
 	if e, ok := err.(*vigo.Error); ok {
 		code = e.Code
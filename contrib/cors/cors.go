@@ -17,7 +17,20 @@ import (
 
 // IsCrossOrigin 完整的跨域判断
 func IsCrossOrigin(r *http.Request) bool {
-	origin := r.Header.Get("Origin")
+	// 获取当前请求的协议
+	scheme := "http"
+	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return crossOrigin(r.Header.Get("Origin"), scheme, r.Host)
+}
+
+// IsCrossOriginX 同 IsCrossOrigin, 协议和主机通过 x.Scheme 和 x.Host 获取, 只信任可信代理添加的转发头
+func IsCrossOriginX(x *vigo.X) bool {
+	return crossOrigin(x.Request.Header.Get("Origin"), x.Scheme(), x.Host())
+}
+
+func crossOrigin(origin, scheme, host string) bool {
 	if origin == "" {
 		return false
 	}
@@ -27,19 +40,13 @@ func IsCrossOrigin(r *http.Request) bool {
 		return false
 	}
 
-	// 获取当前请求的协议
-	scheme := "http"
-	if r.TLS != nil || r.Header.Get("X-Forwarded-Proto") == "https" {
-		scheme = "https"
-	}
-
 	// 获取端口
-	requestPort := getPort(r.Host, scheme)
+	requestPort := getPort(host, scheme)
 	originPort := getPort(originURL.Host, originURL.Scheme)
 
 	// 比较协议、主机、端口
 	return scheme != originURL.Scheme ||
-		getHost(r.Host) != getHost(originURL.Host) ||
+		getHost(host) != getHost(originURL.Host) ||
 		requestPort != originPort
 }
 
@@ -64,7 +71,7 @@ func getPort(host, scheme string) string {
 }
 
 func AllowAny(x *vigo.X) {
-	if IsCrossOrigin(x.Request) {
+	if IsCrossOriginX(x) {
 		origin := x.Request.Header.Get("Origin")
 		x.Header().Set("Access-Control-Allow-Origin", origin)
 		x.Header().Set("Access-Control-Allow-Credentials", "true")
@@ -79,7 +86,7 @@ func AllowAny(x *vigo.X) {
 
 func CorsAllow(domains ...string) func(x *vigo.X) {
 	return func(x *vigo.X) {
-		if IsCrossOrigin(x.Request) {
+		if IsCrossOriginX(x) {
 			origin := x.Request.Header.Get("Origin")
 			if slices.Contains(domains, origin) {
 				x.Header().Set("Access-Control-Allow-Origin", origin)
@@ -0,0 +1,49 @@
+//
+// compress.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package ws
+
+import (
+	"bytes"
+	"compress/flate"
+	"io"
+	"strings"
+	"sync"
+)
+
+// 小于该长度的消息压缩收益不大, 直接发送
+const minCompressSize = 128
+
+var flateWriters = sync.Pool{New: func() any {
+	w, _ := flate.NewWriter(nil, flate.BestSpeed)
+	return w
+}}
+
+// deflate permessage-deflate 压缩, 不保留上下文, 去掉末尾的 00 00 ff ff, 见 RFC 7692 7.2.1
+func deflate(data []byte) []byte {
+	var b bytes.Buffer
+	w := flateWriters.Get().(*flate.Writer)
+	w.Reset(&b)
+	w.Write(data)
+	w.Flush()
+	flateWriters.Put(w)
+	return bytes.TrimSuffix(b.Bytes(), []byte{0, 0, 0xff, 0xff})
+}
+
+// inflate 补上去掉的 00 00 ff ff 和一个结束块, 解压后超过 limit 时返回 1009
+func inflate(data []byte, limit int64) ([]byte, error) {
+	r := flate.NewReader(io.MultiReader(bytes.NewReader(data), strings.NewReader("\x00\x00\xff\xff\x01\x00\x00\xff\xff")))
+	defer r.Close()
+	res, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, closeErr(CloseInvalidPayload, "invalid compressed data")
+	}
+	if int64(len(res)) > limit {
+		return nil, closeErr(CloseTooLarge, "")
+	}
+	return res, nil
+}
@@ -0,0 +1,420 @@
+//
+// conn.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package ws
+
+import (
+	"bufio"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"slices"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+type MessageType int
+
+const (
+	TextMessage   MessageType = 1
+	BinaryMessage MessageType = 2
+)
+
+const (
+	opContinuation = 0x0
+	opText         = 0x1
+	opBinary       = 0x2
+	opClose        = 0x8
+	opPing         = 0x9
+	opPong         = 0xa
+)
+
+// 关闭码, 见 RFC 6455 7.4.1
+const (
+	CloseNormal             = 1000
+	CloseGoingAway          = 1001
+	CloseProtocolError      = 1002
+	CloseUnsupportedData    = 1003
+	CloseNoStatus           = 1005
+	CloseAbnormal           = 1006
+	CloseInvalidPayload     = 1007
+	ClosePolicyViolation    = 1008
+	CloseTooLarge           = 1009
+	CloseMandatoryExtension = 1010
+	CloseInternalError      = 1011
+)
+
+// CloseError 连接关闭的原因, 来自对端的关闭帧或本端检测到的协议错误
+// 对端没有发送关闭帧就断开时 Code 为 CloseAbnormal
+type CloseError struct {
+	Code int
+	Text string
+}
+
+func (e *CloseError) Error() string {
+	return fmt.Sprintf("websocket: close %d %s", e.Code, e.Text)
+}
+
+func closeErr(code int, text string) *CloseError {
+	return &CloseError{Code: code, Text: text}
+}
+
+// Conn 一个 WebSocket 连接
+// ReadMessage 同时只能在一个 goroutine 中调用, 写入方法可以并发调用
+type Conn struct {
+	// 协商的子协议, 没有时为空
+	Subprotocol string
+
+	conn     net.Conn
+	br       *bufio.Reader
+	cfg      Config
+	compress bool
+	rerr     error
+
+	wmu sync.Mutex
+	hdr [10]byte
+	// 广播的发送队列, 第一次广播时创建
+	sendOnce sync.Once
+	send     chan *prepared
+
+	mu        sync.Mutex
+	closed    bool
+	sentClose bool
+	done      chan struct{}
+	hooks     []func()
+}
+
+func newConn(conn net.Conn, br *bufio.Reader, cfg Config, compress bool) *Conn {
+	c := &Conn{conn: conn, br: br, cfg: cfg, compress: compress, done: make(chan struct{})}
+	if cfg.PingInterval > 0 {
+		go c.keepalive()
+	}
+	return c
+}
+
+func (c *Conn) keepalive() {
+	t := time.NewTicker(c.cfg.PingInterval)
+	defer t.Stop()
+	for {
+		select {
+		case <-c.done:
+			return
+		case <-t.C:
+			if err := c.writeFrame(opPing, false, nil); err != nil {
+				c.shutdown()
+				return
+			}
+		}
+	}
+}
+
+// RemoteAddr 连接的对端地址, 经过代理时为代理的地址, 客户端地址使用 x.GetRemoteIP
+func (c *Conn) RemoteAddr() net.Addr {
+	return c.conn.RemoteAddr()
+}
+
+// Done 连接关闭时关闭的 channel
+func (c *Conn) Done() <-chan struct{} {
+	return c.done
+}
+
+// OnClose 注册连接关闭时的回调, 按注册的逆序执行, 已关闭时立即执行
+func (c *Conn) OnClose(fc func()) {
+	c.mu.Lock()
+	if !c.closed {
+		c.hooks = append(c.hooks, fc)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	fc()
+}
+
+// Close 发送关闭帧并断开连接, 重复调用时只发送一次
+func (c *Conn) Close(code int, text string) error {
+	err := c.writeClose(code, text)
+	c.shutdown()
+	return err
+}
+
+func (c *Conn) isClosed() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.closed
+}
+
+func (c *Conn) shutdown() {
+	c.mu.Lock()
+	if c.closed {
+		c.mu.Unlock()
+		return
+	}
+	c.closed = true
+	hooks := c.hooks
+	c.hooks = nil
+	c.mu.Unlock()
+	close(c.done)
+	c.conn.Close()
+	for _, fc := range slices.Backward(hooks) {
+		fc()
+	}
+}
+
+func (c *Conn) writeClose(code int, text string) error {
+	c.mu.Lock()
+	if c.sentClose || c.closed {
+		c.mu.Unlock()
+		return nil
+	}
+	c.sentClose = true
+	c.mu.Unlock()
+	var payload []byte
+	if code != CloseNoStatus {
+		// 控制帧最多 125 字节
+		if len(text) > 123 {
+			text = text[:123]
+		}
+		payload = binary.BigEndian.AppendUint16(nil, uint16(code))
+		payload = append(payload, text...)
+	}
+	return c.writeFrame(opClose, false, payload)
+}
+
+// WriteMessage 发送一条消息, 启用压缩时较长的消息会被压缩
+func (c *Conn) WriteMessage(typ MessageType, data []byte) error {
+	if typ != TextMessage && typ != BinaryMessage {
+		return fmt.Errorf("websocket: invalid message type %d", typ)
+	}
+	if c.compress && len(data) >= minCompressSize {
+		return c.writeFrame(byte(typ), true, deflate(data))
+	}
+	return c.writeFrame(byte(typ), false, data)
+}
+
+// WriteJSON 将 v 编码为 JSON 并作为文本消息发送
+func (c *Conn) WriteJSON(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return c.WriteMessage(TextMessage, b)
+}
+
+// Ping 发送 ping, 对端的 pong 由 ReadMessage 处理
+func (c *Conn) Ping(data []byte) error {
+	if len(data) > 125 {
+		return errors.New("websocket: control frame too large")
+	}
+	return c.writeFrame(opPing, false, data)
+}
+
+func appendHeader(b []byte, op byte, rsv1 bool, n int) []byte {
+	b0 := 0x80 | op
+	if rsv1 {
+		b0 |= 0x40
+	}
+	switch {
+	case n <= 125:
+		return append(b, b0, byte(n))
+	case n <= 0xffff:
+		return binary.BigEndian.AppendUint16(append(b, b0, 126), uint16(n))
+	default:
+		return binary.BigEndian.AppendUint64(append(b, b0, 127), uint64(n))
+	}
+}
+
+// writeFrame 服务端发送的帧不加掩码, 每条消息只使用一帧
+func (c *Conn) writeFrame(op byte, rsv1 bool, payload []byte) error {
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.write(appendHeader(c.hdr[:0], op, rsv1, len(payload)), payload)
+}
+
+func (c *Conn) write(bufs ...[]byte) error {
+	if c.cfg.WriteTimeout > 0 {
+		c.conn.SetWriteDeadline(time.Now().Add(c.cfg.WriteTimeout))
+	}
+	nb := net.Buffers(bufs)
+	_, err := nb.WriteTo(c.conn)
+	return err
+}
+
+type frame struct {
+	fin     bool
+	rsv1    bool
+	op      byte
+	payload []byte
+}
+
+func (c *Conn) readFrame() (frame, error) {
+	var f frame
+	if c.cfg.PingInterval > 0 {
+		c.conn.SetReadDeadline(time.Now().Add(2 * c.cfg.PingInterval))
+	}
+	var buf [8]byte
+	if _, err := io.ReadFull(c.br, buf[:2]); err != nil {
+		return f, err
+	}
+	f.fin = buf[0]&0x80 != 0
+	f.rsv1 = buf[0]&0x40 != 0
+	f.op = buf[0] & 0x0f
+	if buf[0]&0x30 != 0 || f.rsv1 && (!c.compress || f.op == opContinuation || f.op >= opClose) {
+		return f, closeErr(CloseProtocolError, "reserved bits set")
+	}
+	if buf[1]&0x80 == 0 {
+		return f, closeErr(CloseProtocolError, "client frame not masked")
+	}
+	n := uint64(buf[1] & 0x7f)
+	switch n {
+	case 126:
+		if _, err := io.ReadFull(c.br, buf[:2]); err != nil {
+			return f, err
+		}
+		n = uint64(binary.BigEndian.Uint16(buf[:2]))
+	case 127:
+		if _, err := io.ReadFull(c.br, buf[:8]); err != nil {
+			return f, err
+		}
+		n = binary.BigEndian.Uint64(buf[:8])
+		if n>>63 != 0 {
+			return f, closeErr(CloseProtocolError, "invalid payload length")
+		}
+	}
+	if f.op >= opClose && (!f.fin || n > 125) {
+		return f, closeErr(CloseProtocolError, "invalid control frame")
+	}
+	if n > uint64(c.cfg.ReadLimit) {
+		return f, closeErr(CloseTooLarge, "")
+	}
+	var mask [4]byte
+	if _, err := io.ReadFull(c.br, mask[:]); err != nil {
+		return f, err
+	}
+	f.payload = make([]byte, n)
+	if _, err := io.ReadFull(c.br, f.payload); err != nil {
+		return f, err
+	}
+	for i := range f.payload {
+		f.payload[i] ^= mask[i&3]
+	}
+	return f, nil
+}
+
+// ReadMessage 读取一条完整的消息, ping 自动回复 pong, 收到关闭帧时回复并返回 *CloseError
+// 返回错误后连接已关闭, 之后的调用返回同样的错误
+func (c *Conn) ReadMessage() (MessageType, []byte, error) {
+	if c.rerr != nil {
+		return 0, nil, c.rerr
+	}
+	var typ MessageType
+	var data []byte
+	compressed := false
+	for {
+		f, err := c.readFrame()
+		if err != nil {
+			return 0, nil, c.fail(err)
+		}
+		switch f.op {
+		case opPing:
+			if err := c.writeFrame(opPong, false, f.payload); err != nil {
+				return 0, nil, c.fail(err)
+			}
+			continue
+		case opPong:
+			continue
+		case opClose:
+			return 0, nil, c.handleClose(f.payload)
+		case opText, opBinary:
+			if typ != 0 {
+				return 0, nil, c.fail(closeErr(CloseProtocolError, "expected continuation frame"))
+			}
+			typ = MessageType(f.op)
+			compressed = f.rsv1
+		case opContinuation:
+			if typ == 0 {
+				return 0, nil, c.fail(closeErr(CloseProtocolError, "unexpected continuation frame"))
+			}
+		default:
+			return 0, nil, c.fail(closeErr(CloseProtocolError, "unknown opcode"))
+		}
+		if int64(len(data)+len(f.payload)) > c.cfg.ReadLimit {
+			return 0, nil, c.fail(closeErr(CloseTooLarge, ""))
+		}
+		data = append(data, f.payload...)
+		if f.fin {
+			break
+		}
+	}
+	if compressed {
+		var err error
+		if data, err = inflate(data, c.cfg.ReadLimit); err != nil {
+			return 0, nil, c.fail(err)
+		}
+	}
+	if typ == TextMessage && !utf8.Valid(data) {
+		return 0, nil, c.fail(closeErr(CloseInvalidPayload, "invalid utf-8"))
+	}
+	return typ, data, nil
+}
+
+// ReadJSON 读取一条消息并解析到 v
+func (c *Conn) ReadJSON(v any) error {
+	_, data, err := c.ReadMessage()
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// fail 协议错误时发送对应的关闭帧, 之后断开连接
+func (c *Conn) fail(err error) error {
+	var ce *CloseError
+	if errors.As(err, &ce) {
+		c.writeClose(ce.Code, ce.Text)
+	} else if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+		err = closeErr(CloseAbnormal, "unexpected EOF")
+	}
+	c.rerr = err
+	c.shutdown()
+	return err
+}
+
+func validCloseCode(code int) bool {
+	switch {
+	case code >= 1000 && code <= 1014:
+		return code != 1004 && code != CloseNoStatus && code != CloseAbnormal
+	case code >= 3000 && code <= 4999:
+		return true
+	}
+	return false
+}
+
+// handleClose 回复对端的关闭帧, 回复相同的关闭码
+func (c *Conn) handleClose(payload []byte) error {
+	ce := closeErr(CloseNoStatus, "")
+	if len(payload) == 1 {
+		return c.fail(closeErr(CloseProtocolError, "invalid close frame"))
+	}
+	if len(payload) >= 2 {
+		ce.Code = int(binary.BigEndian.Uint16(payload))
+		ce.Text = string(payload[2:])
+		if !validCloseCode(ce.Code) {
+			return c.fail(closeErr(CloseProtocolError, "invalid close code"))
+		}
+		if !utf8.ValidString(ce.Text) {
+			return c.fail(closeErr(CloseInvalidPayload, "invalid utf-8"))
+		}
+	}
+	c.writeClose(ce.Code, "")
+	c.rerr = ce
+	c.shutdown()
+	return ce
+}
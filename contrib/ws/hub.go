@@ -0,0 +1,197 @@
+//
+// hub.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package ws
+
+import (
+	"encoding/json"
+	"slices"
+	"sync"
+)
+
+// Hub 按房间管理连接并广播消息, 连接关闭时自动退出所有房间
+//
+//	hub := ws.NewHub()
+//	router.Get("/ws/:room", vigo.Timeout(-1), ws.Handler(func(x *vigo.X, c *ws.Conn) error {
+//		hub.Join(c, x.Params.Get("room"))
+//		for {
+//			_, data, err := c.ReadMessage()
+//			if err != nil {
+//				return err
+//			}
+//			hub.Broadcast(x.Params.Get("room"), ws.TextMessage, data, c)
+//		}
+//	}))
+type Hub struct {
+	mu    sync.RWMutex
+	rooms map[string]map[*Conn]struct{}
+	conns map[*Conn]map[string]struct{}
+}
+
+func NewHub() *Hub {
+	return &Hub{
+		rooms: make(map[string]map[*Conn]struct{}),
+		conns: make(map[*Conn]map[string]struct{}),
+	}
+}
+
+// Join 加入房间, 同一个连接可以加入多个房间
+func (h *Hub) Join(c *Conn, rooms ...string) {
+	h.mu.Lock()
+	joined, ok := h.conns[c]
+	if !ok {
+		joined = make(map[string]struct{})
+		h.conns[c] = joined
+	}
+	for _, room := range rooms {
+		if h.rooms[room] == nil {
+			h.rooms[room] = make(map[*Conn]struct{})
+		}
+		h.rooms[room][c] = struct{}{}
+		joined[room] = struct{}{}
+	}
+	h.mu.Unlock()
+	if !ok {
+		// 已关闭的连接会立即执行, 不能持有锁
+		c.OnClose(func() { h.Leave(c) })
+	}
+}
+
+// Leave 退出房间, 不指定房间时退出所有房间
+func (h *Hub) Leave(c *Conn, rooms ...string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	joined := h.conns[c]
+	if len(rooms) == 0 {
+		for room := range joined {
+			rooms = append(rooms, room)
+		}
+	}
+	for _, room := range rooms {
+		delete(h.rooms[room], c)
+		if len(h.rooms[room]) == 0 {
+			delete(h.rooms, room)
+		}
+		delete(joined, room)
+	}
+	if len(joined) == 0 {
+		delete(h.conns, c)
+	}
+}
+
+// Rooms 连接加入的房间
+func (h *Hub) Rooms(c *Conn) []string {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	var res []string
+	for room := range h.conns[c] {
+		res = append(res, room)
+	}
+	slices.Sort(res)
+	return res
+}
+
+// Count 房间中的连接数
+func (h *Hub) Count(room string) int {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return len(h.rooms[room])
+}
+
+// Broadcast 将消息放入房间中除 except 外的连接的发送队列, 不等待写入完成, 返回放入队列的连接数
+// 消息只编码一次, 队列已满或发送失败的连接会被关闭并退出房间, 见 Config.SendQueue
+// 广播与 WriteMessage 直接写入的消息之间不保证顺序
+func (h *Hub) Broadcast(room string, typ MessageType, data []byte, except ...*Conn) int {
+	h.mu.RLock()
+	targets := make([]*Conn, 0, len(h.rooms[room]))
+	for c := range h.rooms[room] {
+		if !slices.Contains(except, c) {
+			targets = append(targets, c)
+		}
+	}
+	h.mu.RUnlock()
+	pm := &prepared{typ: typ, data: data}
+	n := 0
+	for _, c := range targets {
+		if c.enqueue(pm) {
+			n++
+		}
+	}
+	return n
+}
+
+// BroadcastJSON 将 v 编码为 JSON 并作为文本消息广播
+func (h *Hub) BroadcastJSON(room string, v any, except ...*Conn) (int, error) {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return 0, err
+	}
+	return h.Broadcast(room, TextMessage, b, except...), nil
+}
+
+// prepared 广播时缓存编码后的帧, 压缩和不压缩各一份
+type prepared struct {
+	typ    MessageType
+	data   []byte
+	once   [2]sync.Once
+	frames [2][]byte
+}
+
+func (p *prepared) frame(compress bool) []byte {
+	i := 0
+	if compress && len(p.data) >= minCompressSize {
+		i = 1
+	}
+	p.once[i].Do(func() {
+		payload := p.data
+		if i == 1 {
+			payload = deflate(p.data)
+		}
+		p.frames[i] = append(appendHeader(nil, byte(p.typ), i == 1, len(payload)), payload...)
+	})
+	return p.frames[i]
+}
+
+// enqueue 放入发送队列, 队列已满时关闭连接
+func (c *Conn) enqueue(p *prepared) bool {
+	c.sendOnce.Do(func() {
+		c.send = make(chan *prepared, c.cfg.SendQueue)
+		go c.sendLoop()
+	})
+	if c.isClosed() {
+		return false
+	}
+	select {
+	case c.send <- p:
+		return true
+	default:
+		// 慢速连接, 关闭后阻塞的写入会立即返回
+		c.shutdown()
+		return false
+	}
+}
+
+func (c *Conn) sendLoop() {
+	for {
+		select {
+		case p := <-c.send:
+			if err := c.writePrepared(p); err != nil {
+				c.shutdown()
+				return
+			}
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *Conn) writePrepared(p *prepared) error {
+	b := p.frame(c.compress)
+	c.wmu.Lock()
+	defer c.wmu.Unlock()
+	return c.write(b)
+}
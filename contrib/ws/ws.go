@@ -0,0 +1,254 @@
+//
+// ws.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+// Package ws RFC 6455 WebSocket, 在 vigo 处理链中升级, 升级前的中间件 (如鉴权) 正常执行
+//
+//	router.Get("/ws", vigo.Timeout(-1), auth, ws.Handler(func(x *vigo.X, c *ws.Conn) error {
+//		for {
+//			typ, data, err := c.ReadMessage()
+//			if err != nil {
+//				return err
+//			}
+//			if err := c.WriteMessage(typ, data); err != nil {
+//				return err
+//			}
+//		}
+//	}))
+package ws
+
+import (
+	"crypto/sha1"
+	"encoding/base64"
+	"net/http"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/vyes-ai/vigo"
+	"github.com/vyes-ai/vigo/contrib/cors"
+	"github.com/vyes-ai/vigo/logv"
+)
+
+var (
+	ErrBadHandshake       = vigo.NewError("websocket: bad handshake")
+	ErrOriginNotAllowed   = vigo.NewError("websocket: origin not allowed").WithCode(http.StatusForbidden)
+	ErrVersionUnsupported = vigo.NewError("websocket: unsupported version").WithCode(http.StatusUpgradeRequired)
+)
+
+const (
+	acceptGUID       = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+	defaultReadLimit = 16 << 20
+	defaultSendQueue = 64
+)
+
+type Config struct {
+	// 检查 Origin, 返回 false 时拒绝升级, 为 nil 时只允许同源或没有 Origin 的请求
+	CheckOrigin func(x *vigo.X) bool
+	// 支持的子协议, 按客户端的顺序选择第一个支持的
+	Subprotocols []string
+	// 启用 permessage-deflate, 不保留压缩上下文
+	Compress bool
+	// 单条消息的最大字节数, 超过时以 1009 关闭连接, 小于等于 0 时使用默认的 16MB
+	ReadLimit int64
+	// 定时发送 ping 的间隔, 超过两个间隔没有收到任何数据时断开, 为 0 时不发送
+	PingInterval time.Duration
+	// 单次写入的超时时间, 为 0 时不限制
+	WriteTimeout time.Duration
+	// Hub 广播的发送队列长度, 小于等于 0 时使用默认的 64, 队列满时关闭连接, 避免慢速连接阻塞广播
+	SendQueue int
+}
+
+func WithCheckOrigin(fc func(x *vigo.X) bool) func(*Config) {
+	return func(c *Config) {
+		c.CheckOrigin = fc
+	}
+}
+
+func WithSubprotocols(protocols ...string) func(*Config) {
+	return func(c *Config) {
+		c.Subprotocols = protocols
+	}
+}
+
+func WithCompress() func(*Config) {
+	return func(c *Config) {
+		c.Compress = true
+	}
+}
+
+func WithReadLimit(n int64) func(*Config) {
+	return func(c *Config) {
+		c.ReadLimit = n
+	}
+}
+
+func WithPingInterval(d time.Duration) func(*Config) {
+	return func(c *Config) {
+		c.PingInterval = d
+	}
+}
+
+func WithWriteTimeout(d time.Duration) func(*Config) {
+	return func(c *Config) {
+		c.WriteTimeout = d
+	}
+}
+
+func WithSendQueue(n int) func(*Config) {
+	return func(c *Config) {
+		c.SendQueue = n
+	}
+}
+
+// Handler 升级为 WebSocket 后调用 fc, fc 返回后关闭连接, 返回错误时关闭码为 1011
+// 握手失败时返回的错误交给处理链中的错误处理函数
+// 路由配置了超时时 x.Context() 会被取消, 长连接需要使用 vigo.Timeout(-1)
+func Handler(fc func(x *vigo.X, c *Conn) error, opts ...func(*Config)) func(x *vigo.X) error {
+	return func(x *vigo.X) error {
+		c, err := Upgrade(x, opts...)
+		if err != nil {
+			return err
+		}
+		// 连接已被接管, 后续处理函数不能再写入响应
+		x.Stop()
+		defer c.Close(CloseNormal, "")
+		if err := fc(x, c); err != nil {
+			if _, ok := err.(*CloseError); !ok {
+				logv.Warn().Msgf("websocket %s: %v", x.Request.URL.Path, err)
+				c.Close(CloseInternalError, "")
+			}
+		}
+		return nil
+	}
+}
+
+// Upgrade 校验握手请求并接管连接, 失败时返回 *vigo.Error, 此时连接未被接管
+// 中间件写入 x.Header() 的头 (如 Set-Cookie) 会随握手响应发送
+func Upgrade(x *vigo.X, opts ...func(*Config)) (*Conn, error) {
+	cfg := Config{}
+	for _, opt := range opts {
+		opt(&cfg)
+	}
+	if cfg.ReadLimit <= 0 {
+		cfg.ReadLimit = defaultReadLimit
+	}
+	if cfg.SendQueue <= 0 {
+		cfg.SendQueue = defaultSendQueue
+	}
+	req := x.Request
+	if req.Method != http.MethodGet {
+		return nil, ErrBadHandshake.WithMessage("websocket: bad handshake: method is not GET")
+	}
+	if !headerContains(req.Header, "Connection", "upgrade") || !headerContains(req.Header, "Upgrade", "websocket") {
+		return nil, ErrBadHandshake.WithMessage("websocket: bad handshake: not a websocket upgrade")
+	}
+	if req.Header.Get("Sec-WebSocket-Version") != "13" {
+		x.Header().Set("Sec-WebSocket-Version", "13")
+		return nil, ErrVersionUnsupported
+	}
+	key := req.Header.Get("Sec-WebSocket-Key")
+	if b, err := base64.StdEncoding.DecodeString(key); err != nil || len(b) != 16 {
+		return nil, ErrBadHandshake.WithMessage("websocket: bad handshake: invalid Sec-WebSocket-Key")
+	}
+	if cfg.CheckOrigin != nil && !cfg.CheckOrigin(x) || cfg.CheckOrigin == nil && cors.IsCrossOriginX(x) {
+		return nil, ErrOriginNotAllowed
+	}
+	protocol := ""
+	for _, p := range headerTokens(req.Header, "Sec-WebSocket-Protocol") {
+		if slices.Contains(cfg.Subprotocols, p) {
+			protocol = p
+			break
+		}
+	}
+	compress := cfg.Compress && acceptDeflate(req.Header)
+	conn, brw, err := x.ResponseWriter().(http.Hijacker).Hijack()
+	if err != nil {
+		return nil, vigo.ErrNotSupported.WithMessage("websocket: hijack: " + err.Error())
+	}
+	var sb strings.Builder
+	sb.WriteString("HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: ")
+	sb.WriteString(acceptKey(key))
+	sb.WriteString("\r\n")
+	if protocol != "" {
+		sb.WriteString("Sec-WebSocket-Protocol: " + protocol + "\r\n")
+	}
+	if compress {
+		sb.WriteString("Sec-WebSocket-Extensions: permessage-deflate; server_no_context_takeover; client_no_context_takeover\r\n")
+	}
+	for k, vs := range x.Header() {
+		switch k {
+		case "Upgrade", "Connection", "Sec-Websocket-Accept", "Sec-Websocket-Protocol", "Sec-Websocket-Extensions", "Content-Type", "Content-Length":
+			continue
+		}
+		for _, v := range vs {
+			sb.WriteString(k + ": " + strings.NewReplacer("\r", "", "\n", "").Replace(v) + "\r\n")
+		}
+	}
+	sb.WriteString("\r\n")
+	if cfg.WriteTimeout > 0 {
+		conn.SetWriteDeadline(time.Now().Add(cfg.WriteTimeout))
+	}
+	if _, err := conn.Write([]byte(sb.String())); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	c := newConn(conn, brw.Reader, cfg, compress)
+	c.Subprotocol = protocol
+	return c, nil
+}
+
+func acceptKey(key string) string {
+	h := sha1.New()
+	h.Write([]byte(key + acceptGUID))
+	return base64.StdEncoding.EncodeToString(h.Sum(nil))
+}
+
+// headerTokens 逗号分隔的多个值
+func headerTokens(h http.Header, key string) []string {
+	var res []string
+	for _, v := range h.Values(key) {
+		for _, t := range strings.Split(v, ",") {
+			if t = strings.TrimSpace(t); t != "" {
+				res = append(res, t)
+			}
+		}
+	}
+	return res
+}
+
+func headerContains(h http.Header, key, token string) bool {
+	return slices.ContainsFunc(headerTokens(h, key), func(t string) bool {
+		return strings.EqualFold(t, token)
+	})
+}
+
+// acceptDeflate 客户端提供的 permessage-deflate 参数是否都能满足
+// 服务端总是使用 32KB 窗口, 客户端要求更小的 server_max_window_bits 时不启用
+func acceptDeflate(h http.Header) bool {
+	for _, offer := range headerTokens(h, "Sec-WebSocket-Extensions") {
+		params := strings.Split(offer, ";")
+		if strings.TrimSpace(params[0]) != "permessage-deflate" {
+			continue
+		}
+		ok := true
+		for _, p := range params[1:] {
+			k, v, _ := strings.Cut(strings.TrimSpace(p), "=")
+			switch k {
+			case "server_no_context_takeover", "client_no_context_takeover", "client_max_window_bits":
+			case "server_max_window_bits":
+				ok = ok && strings.Trim(v, `"`) == "15"
+			default:
+				ok = false
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
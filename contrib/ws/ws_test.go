@@ -0,0 +1,382 @@
+//
+// ws_test.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package ws
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/vyes-ai/vigo"
+	"github.com/vyes-ai/vigo/contrib/common"
+)
+
+// client 测试用的客户端, 直接读写帧
+type client struct {
+	t    *testing.T
+	conn net.Conn
+	br   *bufio.Reader
+	resp *http.Response
+}
+
+func dial(t *testing.T, srv *httptest.Server, path string, header http.Header) *client {
+	t.Helper()
+	conn, err := net.Dial("tcp", srv.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	req, err := http.NewRequest(http.MethodGet, srv.URL+path, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := make([]byte, 16)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Connection", "Upgrade")
+	req.Header.Set("Upgrade", "websocket")
+	req.Header.Set("Sec-WebSocket-Version", "13")
+	req.Header.Set("Sec-WebSocket-Key", base64.StdEncoding.EncodeToString(key))
+	for k, vs := range header {
+		req.Header[k] = vs
+	}
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	c := &client{t: t, conn: conn, br: bufio.NewReader(conn)}
+	if c.resp, err = http.ReadResponse(c.br, req); err != nil {
+		t.Fatal(err)
+	}
+	if c.resp.StatusCode == http.StatusSwitchingProtocols && c.resp.Header.Get("Sec-WebSocket-Accept") != acceptKey(req.Header.Get("Sec-WebSocket-Key")) {
+		t.Fatalf("invalid accept key %q", c.resp.Header.Get("Sec-WebSocket-Accept"))
+	}
+	return c
+}
+
+func (c *client) send(op byte, fin, rsv1 bool, payload []byte) {
+	c.t.Helper()
+	b := appendHeader(nil, op, rsv1, len(payload))
+	if !fin {
+		b[0] &^= 0x80
+	}
+	b[1] |= 0x80
+	mask := []byte{1, 2, 3, 4}
+	b = append(b, mask...)
+	for i, v := range payload {
+		b = append(b, v^mask[i&3])
+	}
+	c.write(b)
+}
+
+// write 写入原始数据, 失败时终止测试
+func (c *client) write(b []byte) {
+	c.t.Helper()
+	if _, err := c.conn.Write(b); err != nil {
+		c.t.Fatalf("write: %v", err)
+	}
+}
+
+// read 读满 b, 失败时终止测试
+func (c *client) read(b []byte) {
+	c.t.Helper()
+	if _, err := io.ReadFull(c.br, b); err != nil {
+		c.t.Fatalf("read: %v", err)
+	}
+}
+
+func (c *client) recv() (op byte, rsv1 bool, payload []byte) {
+	c.t.Helper()
+	var h [2]byte
+	c.read(h[:])
+	op, rsv1 = h[0]&0x0f, h[0]&0x40 != 0
+	n := int(h[1] & 0x7f)
+	switch n {
+	case 126:
+		var b [2]byte
+		c.read(b[:])
+		n = int(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		c.read(b[:])
+		n = int(binary.BigEndian.Uint64(b[:]))
+	}
+	payload = make([]byte, n)
+	c.read(payload)
+	return
+}
+
+// recvClose 读取关闭帧的关闭码
+func (c *client) recvClose(t *testing.T) int {
+	t.Helper()
+	op, _, payload := c.recv()
+	if op != opClose || len(payload) < 2 {
+		t.Fatalf("expect close frame, got op %d %q", op, payload)
+	}
+	return int(binary.BigEndian.Uint16(payload))
+}
+
+func newServer(t *testing.T, r vigo.Router) *httptest.Server {
+	srv := httptest.NewServer(r)
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+func echo(x *vigo.X, c *Conn) error {
+	for {
+		typ, data, err := c.ReadMessage()
+		if err != nil {
+			return err
+		}
+		if err := c.WriteMessage(typ, data); err != nil {
+			return err
+		}
+	}
+}
+
+func TestUpgrade(t *testing.T) {
+	r := vigo.NewRouter()
+	r.Get("/ws", func(x *vigo.X) error {
+		if x.Request.Header.Get("Token") != "t" {
+			return vigo.NewError("no token").WithCode(http.StatusUnauthorized)
+		}
+		x.SetCookie("session", "s1")
+		return nil
+	}, Handler(func(x *vigo.X, c *Conn) error {
+		return c.WriteMessage(TextMessage, []byte(c.Subprotocol))
+	}, WithSubprotocols("v2", "v1")))
+	r.UseAfter(common.JsonErrorResponse)
+	srv := newServer(t, r)
+	cases := []struct {
+		name   string
+		header http.Header
+		status int
+	}{
+		{"no token", http.Header{}, http.StatusUnauthorized},
+		{"bad version", http.Header{"Token": {"t"}, "Sec-Websocket-Version": {"8"}}, http.StatusUpgradeRequired},
+		{"cross origin", http.Header{"Token": {"t"}, "Origin": {"http://evil.example"}}, http.StatusForbidden},
+		{"ok", http.Header{"Token": {"t"}, "Sec-Websocket-Protocol": {"v1, v2"}, "Origin": {srv.URL}}, http.StatusSwitchingProtocols},
+	}
+	for _, tc := range cases {
+		c := dial(t, srv, "/ws", tc.header)
+		if c.resp.StatusCode != tc.status {
+			t.Errorf("%s: expect %d, got %d", tc.name, tc.status, c.resp.StatusCode)
+		}
+		if tc.status == http.StatusUpgradeRequired && c.resp.Header.Get("Sec-WebSocket-Version") != "13" {
+			t.Errorf("%s: missing Sec-WebSocket-Version", tc.name)
+		}
+		if tc.status != http.StatusSwitchingProtocols {
+			continue
+		}
+		if c.resp.Header.Get("Sec-WebSocket-Protocol") != "v1" || !strings.HasPrefix(c.resp.Header.Get("Set-Cookie"), "session=s1") {
+			t.Errorf("%s: unexpected header %v", tc.name, c.resp.Header)
+		}
+		if _, _, payload := c.recv(); string(payload) != "v1" {
+			t.Errorf("%s: expect v1, got %q", tc.name, payload)
+		}
+		if code := c.recvClose(t); code != CloseNormal {
+			t.Errorf("%s: expect close %d, got %d", tc.name, CloseNormal, code)
+		}
+	}
+}
+
+func TestConn(t *testing.T) {
+	closed := make(chan error, 1)
+	r := vigo.NewRouter()
+	r.Get("/echo", Handler(func(x *vigo.X, c *Conn) error {
+		err := echo(x, c)
+		closed <- err
+		return err
+	}, WithReadLimit(1024)))
+	r.Get("/default-limit", Handler(echo, WithReadLimit(0)))
+	srv := newServer(t, r)
+
+	// ReadLimit 为 0 时使用默认值
+	u := dial(t, srv, "/default-limit", nil)
+	u.send(opText, true, false, []byte("hello"))
+	if op, _, payload := u.recv(); op != opText || string(payload) != "hello" {
+		t.Errorf("read limit 0: expect text hello, got %d %q", op, payload)
+	}
+
+	c := dial(t, srv, "/echo", nil)
+	c.send(opText, true, false, []byte("hello"))
+	if op, _, payload := c.recv(); op != opText || string(payload) != "hello" {
+		t.Errorf("expect text hello, got %d %q", op, payload)
+	}
+	// 分片消息中间插入 ping
+	c.send(opBinary, false, false, []byte("ab"))
+	c.send(opPing, true, false, []byte("p"))
+	c.send(opContinuation, true, false, []byte("cd"))
+	if op, _, payload := c.recv(); op != opPong || string(payload) != "p" {
+		t.Errorf("expect pong, got %d %q", op, payload)
+	}
+	if op, _, payload := c.recv(); op != opBinary || string(payload) != "abcd" {
+		t.Errorf("expect binary abcd, got %d %q", op, payload)
+	}
+	c.send(opClose, true, false, append(binary.BigEndian.AppendUint16(nil, 4000), "bye"...))
+	if code := c.recvClose(t); code != 4000 {
+		t.Errorf("expect close 4000, got %d", code)
+	}
+	var ce *CloseError
+	if err := <-closed; !errors.As(err, &ce) || ce.Code != 4000 || ce.Text != "bye" {
+		t.Errorf("expect close error 4000 bye, got %v", err)
+	}
+
+	cases := []struct {
+		name string
+		send func(c *client)
+		code int
+	}{
+		{"unmasked", func(c *client) { c.write([]byte{0x81, 0x01, 'a'}) }, CloseProtocolError},
+		{"invalid utf8", func(c *client) { c.send(opText, true, false, []byte{0xff, 0xfe}) }, CloseInvalidPayload},
+		{"too large", func(c *client) { c.send(opBinary, true, false, make([]byte, 2048)) }, CloseTooLarge},
+		{"bad continuation", func(c *client) { c.send(opContinuation, true, false, []byte("a")) }, CloseProtocolError},
+		{"rsv1 without deflate", func(c *client) { c.send(opText, true, true, []byte("a")) }, CloseProtocolError},
+		{"fragmented ping", func(c *client) { c.send(opPing, false, false, nil) }, CloseProtocolError},
+		{"bad close code", func(c *client) { c.send(opClose, true, false, binary.BigEndian.AppendUint16(nil, 1005)) }, CloseProtocolError},
+	}
+	for _, tc := range cases {
+		c := dial(t, srv, "/echo", nil)
+		tc.send(c)
+		if code := c.recvClose(t); code != tc.code {
+			t.Errorf("%s: expect close %d, got %d", tc.name, tc.code, code)
+		}
+		if err := <-closed; !errors.As(err, &ce) || ce.Code != tc.code {
+			t.Errorf("%s: expect close error %d, got %v", tc.name, tc.code, err)
+		}
+	}
+}
+
+func TestConn_Compress(t *testing.T) {
+	r := vigo.NewRouter()
+	r.Get("/echo", Handler(echo, WithCompress()))
+	srv := newServer(t, r)
+	c := dial(t, srv, "/echo", http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; client_max_window_bits"}})
+	if ext := c.resp.Header.Get("Sec-WebSocket-Extensions"); !strings.HasPrefix(ext, "permessage-deflate") {
+		t.Fatalf("expect permessage-deflate, got %q", ext)
+	}
+	msg := bytes.Repeat([]byte("vigo websocket "), 20)
+	c.send(opText, true, true, deflate(msg))
+	op, rsv1, payload := c.recv()
+	if op != opText || !rsv1 || len(payload) >= len(msg) {
+		t.Fatalf("expect compressed text, got %d %v %d", op, rsv1, len(payload))
+	}
+	if got, err := inflate(payload, 1<<20); err != nil || !bytes.Equal(got, msg) {
+		t.Errorf("inflate: %q %v", got, err)
+	}
+	// 短消息不压缩
+	c.send(opText, true, false, []byte("hi"))
+	if _, rsv1, payload := c.recv(); rsv1 || string(payload) != "hi" {
+		t.Errorf("expect plain hi, got %v %q", rsv1, payload)
+	}
+
+	// 无法满足的参数不启用压缩
+	c = dial(t, srv, "/echo", http.Header{"Sec-Websocket-Extensions": {"permessage-deflate; server_max_window_bits=10"}})
+	if ext := c.resp.Header.Get("Sec-WebSocket-Extensions"); ext != "" {
+		t.Errorf("expect no extension, got %q", ext)
+	}
+}
+
+func TestHub(t *testing.T) {
+	hub := NewHub()
+	joined := make(chan struct{}, 3)
+	r := vigo.NewRouter()
+	r.Get("/room/:room", Handler(func(x *vigo.X, c *Conn) error {
+		room := x.Params.Get("room")
+		hub.Join(c, room, "all")
+		joined <- struct{}{}
+		for {
+			_, data, err := c.ReadMessage()
+			if err != nil {
+				return err
+			}
+			hub.Broadcast(room, TextMessage, data, c)
+		}
+	}, WithCompress()))
+	srv := newServer(t, r)
+	a := dial(t, srv, "/room/r1", nil)
+	b := dial(t, srv, "/room/r1", http.Header{"Sec-Websocket-Extensions": {"permessage-deflate"}})
+	o := dial(t, srv, "/room/r2", nil)
+	for range 3 {
+		<-joined
+	}
+	if hub.Count("r1") != 2 || hub.Count("all") != 3 {
+		t.Fatalf("unexpected count r1=%d all=%d", hub.Count("r1"), hub.Count("all"))
+	}
+
+	long := strings.Repeat("x", 200)
+	a.send(opText, true, false, []byte(long))
+	op, rsv1, payload := b.recv()
+	if rsv1 {
+		payload, _ = inflate(payload, 1<<20)
+	}
+	if op != opText || !rsv1 || string(payload) != long {
+		t.Errorf("b: expect compressed message, got %d %v %q", op, rsv1, payload)
+	}
+	if n, err := hub.BroadcastJSON("all", map[string]int{"n": 1}); n != 3 || err != nil {
+		t.Errorf("expect 3 receivers, got %d %v", n, err)
+	}
+	// a 不会收到自己发送的消息, 第一条是 BroadcastJSON 的消息
+	for name, c := range map[string]*client{"a": a, "b": b, "o": o} {
+		if _, _, payload := c.recv(); string(payload) != `{"n":1}` {
+			t.Errorf("%s: expect json, got %q", name, payload)
+		}
+	}
+
+	b.send(opClose, true, false, binary.BigEndian.AppendUint16(nil, CloseNormal))
+	b.recvClose(t)
+	deadline := time.Now().Add(time.Second)
+	for hub.Count("r1") != 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hub.Count("r1") != 1 || hub.Count("all") != 2 {
+		t.Errorf("closed conn not removed: r1=%d all=%d", hub.Count("r1"), hub.Count("all"))
+	}
+}
+
+func TestHub_SlowConsumer(t *testing.T) {
+	hub := NewHub()
+	joined := make(chan struct{}, 1)
+	r := vigo.NewRouter()
+	r.Get("/room", Handler(func(x *vigo.X, c *Conn) error {
+		hub.Join(c, "r")
+		joined <- struct{}{}
+		_, _, err := c.ReadMessage()
+		return err
+	}, WithSendQueue(1)))
+	srv := newServer(t, r)
+	// 不读取数据的客户端, 写入阻塞后队列被填满
+	dial(t, srv, "/room", nil)
+	<-joined
+	data := make([]byte, 1<<20)
+	start := time.Now()
+	for i := 0; i < 64 && hub.Count("r") > 0; i++ {
+		hub.Broadcast("r", BinaryMessage, data)
+	}
+	if d := time.Since(start); d > time.Second {
+		t.Errorf("broadcast blocked by slow consumer: %v", d)
+	}
+	deadline := time.Now().Add(time.Second)
+	for hub.Count("r") != 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if hub.Count("r") != 0 {
+		t.Errorf("slow consumer not removed")
+	}
+}
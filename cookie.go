@@ -0,0 +1,179 @@
+//
+// cookie.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hkdf"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// SetCookie 写入 cookie, 默认 Path=/ HttpOnly SameSite=Lax, HTTPS 请求默认 Secure, 见 X.Scheme
+// opts 可以修改默认值, 如 func(c *http.Cookie) { c.MaxAge = 3600 }
+func (x *X) SetCookie(name, value string, opts ...func(*http.Cookie)) {
+	c := &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+		Secure:   x.Scheme() == "https",
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+	if c.SameSite == http.SameSiteNoneMode {
+		// 浏览器要求 SameSite=None 必须同时设置 Secure
+		c.Secure = true
+	}
+	http.SetCookie(x, c)
+}
+
+// Cookie 读取 cookie 的原始值, 不存在时返回 http.ErrNoCookie
+func (x *X) Cookie(name string) (string, error) {
+	c, err := x.Request.Cookie(name)
+	if err != nil {
+		return "", err
+	}
+	return c.Value, nil
+}
+
+// DeleteCookie 删除 cookie, Path 和 Domain 需要与写入时一致
+func (x *X) DeleteCookie(name string, opts ...func(*http.Cookie)) {
+	x.SetCookie(name, "", append(opts, func(c *http.Cookie) { c.MaxAge = -1 })...)
+}
+
+// SetSignedCookie 写入 HMAC-SHA256 签名的 cookie, 值编码为 JSON, 客户端可以读取但不能修改
+// 签名密钥为 RestConf.CookieKeys 的第一个
+func (x *X) SetSignedCookie(name string, value any, opts ...func(*http.Cookie)) error {
+	keys, err := x.cookieKeys()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	payload := base64.RawURLEncoding.EncodeToString(b)
+	x.SetCookie(name, payload+"."+cookieMAC(keys[0], name, payload), opts...)
+	return nil
+}
+
+// SignedCookie 验证签名并将值解析到 target, 依次尝试 RestConf.CookieKeys 中的密钥
+func (x *X) SignedCookie(name string, target any) error {
+	keys, err := x.cookieKeys()
+	if err != nil {
+		return err
+	}
+	v, err := x.Cookie(name)
+	if err != nil {
+		return err
+	}
+	payload, mac, ok := strings.Cut(v, ".")
+	if !ok {
+		return ErrCookieInvalid.WithMessage("invalid cookie: " + name)
+	}
+	for _, key := range keys {
+		if hmac.Equal([]byte(mac), []byte(cookieMAC(key, name, payload))) {
+			b, err := base64.RawURLEncoding.DecodeString(payload)
+			if err != nil {
+				return ErrCookieInvalid.WithMessage("invalid cookie: " + name)
+			}
+			return json.Unmarshal(b, target)
+		}
+	}
+	return ErrCookieInvalid.WithMessage("invalid cookie: " + name)
+}
+
+// SetSecureCookie 写入 AES-GCM 加密的 cookie, 值编码为 JSON, 客户端不能读取和修改
+// 加密密钥为 RestConf.CookieKeys 的第一个
+func (x *X) SetSecureCookie(name string, value any, opts ...func(*http.Cookie)) error {
+	keys, err := x.cookieKeys()
+	if err != nil {
+		return err
+	}
+	b, err := json.Marshal(value)
+	if err != nil {
+		return err
+	}
+	aead, err := cookieAEAD(keys[0])
+	if err != nil {
+		return err
+	}
+	nonce := make([]byte, aead.NonceSize(), aead.NonceSize()+len(b)+aead.Overhead())
+	rand.Read(nonce)
+	// cookie 名称作为附加数据, 避免密文被挪用到其它 cookie
+	sealed := aead.Seal(nonce, nonce, b, []byte(name))
+	x.SetCookie(name, base64.RawURLEncoding.EncodeToString(sealed), opts...)
+	return nil
+}
+
+// SecureCookie 解密并将值解析到 target, 依次尝试 RestConf.CookieKeys 中的密钥
+func (x *X) SecureCookie(name string, target any) error {
+	keys, err := x.cookieKeys()
+	if err != nil {
+		return err
+	}
+	v, err := x.Cookie(name)
+	if err != nil {
+		return err
+	}
+	sealed, err := base64.RawURLEncoding.DecodeString(v)
+	if err != nil {
+		return ErrCookieInvalid.WithMessage("invalid cookie: " + name)
+	}
+	for _, key := range keys {
+		aead, err := cookieAEAD(key)
+		if err != nil {
+			return err
+		}
+		if len(sealed) < aead.NonceSize() {
+			break
+		}
+		if b, err := aead.Open(nil, sealed[:aead.NonceSize()], sealed[aead.NonceSize():], []byte(name)); err == nil {
+			return json.Unmarshal(b, target)
+		}
+	}
+	return ErrCookieInvalid.WithMessage("invalid cookie: " + name)
+}
+
+func (x *X) cookieKeys() ([][]byte, error) {
+	if x.app == nil || len(x.app.config.CookieKeys) == 0 {
+		return nil, ErrNotSupported.WithMessage("cookie keys not configured, see WithCookieKeys")
+	}
+	return x.app.config.CookieKeys, nil
+}
+
+// 签名和加密使用从同一个密钥派生的不同子密钥
+func cookieMAC(key []byte, name, payload string) string {
+	sub, _ := hkdf.Key(sha256.New, key, nil, "vigo cookie sign", 32)
+	h := hmac.New(sha256.New, sub)
+	h.Write([]byte(name))
+	h.Write([]byte{0})
+	h.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+func cookieAEAD(key []byte) (cipher.AEAD, error) {
+	sub, err := hkdf.Key(sha256.New, key, nil, "vigo cookie encrypt", 32)
+	if err != nil {
+		return nil, err
+	}
+	block, err := aes.NewCipher(sub)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
@@ -0,0 +1,196 @@
+//
+// domain.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+
+	"github.com/vyes-ai/vigo/logv"
+)
+
+// hostPattern Application.Domain 的域名规则, 匹配时忽略大小写和末尾的 .
+// api.example.com           精确匹配
+// :tenant.example.com       标签参数, 写入 x.Params, 约束规则同路径参数
+// *.example.com             一级或多级子域名, 不匹配 example.com 本身
+// api.example.com:8080      指定端口, 未指定时匹配任意端口
+//
+// 多个规则同时匹配时的顺序:
+// 指定端口优先, 其次非通配符优先, 其次标签越多越优先, 其次字面量标签越多越优先, 其次约束越强越优先, 相同时按注册顺序
+type hostPattern struct {
+	raw string
+	// 从左到右的标签, 参数标签对应的 params 非 nil
+	labels []string
+	params []*segPattern
+	wild   bool
+	port   string
+}
+
+type domainRouter struct {
+	host   *hostPattern
+	router *route
+}
+
+func parseHost(d string) *hostPattern {
+	h := &hostPattern{}
+	host, port := splitHost(d)
+	h.port = port
+	host = strings.TrimSuffix(host, ".")
+	if rest, ok := strings.CutPrefix(host, "*."); ok {
+		h.wild = true
+		host = rest
+	}
+	logv.Assert(host != "", "invalid domain: "+d)
+	for _, label := range strings.Split(host, ".") {
+		logv.Assert(label != "" && label != "*", "invalid domain: "+d)
+		label = lowerLiterals(label)
+		h.labels = append(h.labels, label)
+		h.params = append(h.params, parseSegment(label))
+	}
+	h.raw = strings.Join(h.labels, ".")
+	if h.wild {
+		h.raw = "*." + h.raw
+	}
+	if port != "" {
+		h.raw += ":" + port
+	}
+	return h
+}
+
+// lowerLiterals 标签中的字面量转为小写, 参数名和约束保持不变
+func lowerLiterals(label string) string {
+	b := []byte(label)
+	depth := 0
+	for i := 0; i < len(b); i++ {
+		switch c := b[i]; {
+		case depth > 0:
+			if c == '<' {
+				depth++
+			} else if c == '>' {
+				depth--
+			}
+		case c == ':':
+			for i+1 < len(b) && isNameByte(b[i+1]) {
+				i++
+			}
+			if i+1 < len(b) && b[i+1] == '<' {
+				depth = 1
+				i++
+			}
+		case c >= 'A' && c <= 'Z':
+			b[i] = c + 'a' - 'A'
+		}
+	}
+	return string(b)
+}
+
+// splitHost 拆分 host:port, 兼容 [::1]:8080, 冒号后不是数字时视为域名参数
+func splitHost(h string) (string, string) {
+	i := strings.LastIndexByte(h, ':')
+	if i < 0 || i == len(h)-1 {
+		return h, ""
+	}
+	for _, c := range h[i+1:] {
+		if c < '0' || c > '9' {
+			return h, ""
+		}
+	}
+	return h[:i], h[i+1:]
+}
+
+func (h *hostPattern) exact() bool {
+	return !h.wild && !slices.ContainsFunc(h.params, func(p *segPattern) bool { return p != nil })
+}
+
+// match 从右向左逐个标签匹配, 成功时参数追加到 ps
+func (h *hostPattern) match(host, port string, ps *Params) bool {
+	if h.port != "" && h.port != port {
+		return false
+	}
+	n := len(*ps)
+	for i := len(h.labels) - 1; i >= 0; i-- {
+		if host == "" {
+			*ps = (*ps)[:n]
+			return false
+		}
+		label := host
+		host = ""
+		if idx := strings.LastIndexByte(label, '.'); idx >= 0 {
+			label, host = label[idx+1:], label[:idx]
+		}
+		if p := h.params[i]; p != nil {
+			if !p.match(label, ps) {
+				*ps = (*ps)[:n]
+				return false
+			}
+		} else if label != h.labels[i] {
+			*ps = (*ps)[:n]
+			return false
+		}
+	}
+	if (host != "") != h.wild {
+		*ps = (*ps)[:n]
+		return false
+	}
+	return true
+}
+
+func (h *hostPattern) literals() int {
+	res := 0
+	for _, p := range h.params {
+		if p == nil {
+			res++
+		}
+	}
+	return res
+}
+
+func (h *hostPattern) rank() int {
+	res := 0
+	for _, p := range h.params {
+		if p != nil {
+			res += p.rank()
+		}
+	}
+	return res
+}
+
+func compareHost(a, b *hostPattern) int {
+	if (a.port != "") != (b.port != "") {
+		if a.port != "" {
+			return -1
+		}
+		return 1
+	}
+	if a.wild != b.wild {
+		if b.wild {
+			return -1
+		}
+		return 1
+	}
+	if len(a.labels) != len(b.labels) {
+		return len(b.labels) - len(a.labels)
+	}
+	if la, lb := a.literals(), b.literals(); la != lb {
+		return lb - la
+	}
+	return b.rank() - a.rank()
+}
+
+// requestHost 返回小写的请求域名和端口, 未携带端口时按协议补全
+func requestHost(req *http.Request) (string, string) {
+	host, port := splitHost(req.Host)
+	if port == "" {
+		port = "80"
+		if req.TLS != nil {
+			port = "443"
+		}
+	}
+	return strings.ToLower(strings.TrimSuffix(host, ".")), port
+}
@@ -14,18 +14,25 @@ import (
 )
 
 var (
-	ErrCrash           = NewError("crash")
-	ErrNotFound        = NewError("not found").WithCode(404)
-	ErrArgMissing      = NewError("missing arg: %s").WithCode(http.StatusConflict)
-	ErrArgInvalid      = NewError("invalid arg: %s").WithCode(http.StatusConflict)
-	ErrNotImplemented  = NewError("not implemented")
-	ErrNotAllowed      = NewError("not allowed")
-	ErrNotSupported    = NewError("not supported")
-	ErrNotAuthorized   = NewError("not authorized").WithCode(40101)
-	ErrNotPermitted    = NewError("not permitted").WithCode(40102)
-	ErrForbidden       = NewError("not forbidden").WithCode(http.StatusForbidden)
-	ErrInternalServer  = NewError("internal server error").WithCode(500)
-	ErrTooManyRequests = NewError("too many requests").WithCode(http.StatusTooManyRequests)
+	ErrCrash            = NewError("crash")
+	ErrNotFound         = NewError("not found").WithCode(404)
+	ErrArgMissing       = NewError("missing arg: %s").WithCode(http.StatusConflict)
+	ErrArgInvalid       = NewError("invalid arg: %s").WithCode(http.StatusConflict)
+	ErrNotImplemented   = NewError("not implemented")
+	ErrNotAllowed       = NewError("not allowed")
+	ErrMethodNotAllowed = NewError("method not allowed").WithCode(http.StatusMethodNotAllowed)
+	ErrNotSupported     = NewError("not supported")
+	ErrNotAuthorized    = NewError("not authorized").WithCode(40101)
+	ErrNotPermitted     = NewError("not permitted").WithCode(40102)
+	ErrForbidden        = NewError("not forbidden").WithCode(http.StatusForbidden)
+	ErrInternalServer   = NewError("internal server error").WithCode(500)
+	ErrTooManyRequests  = NewError("too many requests").WithCode(http.StatusTooManyRequests)
+	ErrRouteConflict    = NewError("route conflict").WithCode(500)
+	ErrTimeout          = NewError("request timeout").WithCode(http.StatusGatewayTimeout)
+	ErrNotAcceptable    = NewError("not acceptable").WithCode(http.StatusNotAcceptable)
+	ErrCookieInvalid    = NewError("invalid cookie").WithCode(http.StatusBadRequest)
+	// 客户端断开连接, 响应不会被客户端收到
+	ErrCanceled = NewError("request canceled").WithCode(499)
 )
 
 type Error struct {
@@ -0,0 +1,142 @@
+//
+// hook.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"fmt"
+	"net/http"
+	"slices"
+)
+
+// NotFound 设置路径未匹配时的处理链, 子路由设置的处理链优先于父路由
+// 与普通路由一样经过中间件, 使用的是路径能匹配到的最深的子路由继承的 before/after 中间件
+// 如 /api 下未匹配的路径经过 /api 的中间件, 即使处理链设置在根路由上
+// 不传 handlers 时返回 ErrNotFound, 交由 FuncErr 处理
+func (r *route) NotFound(handlers ...any) Router {
+	if len(handlers) == 0 {
+		handlers = []any{func(x *X) error {
+			return ErrNotFound
+		}}
+	}
+	return r.setHook(http.StatusNotFound, handlers)
+}
+
+// MethodNotAllowed 设置路径匹配但方法未注册时的处理链, Allow 头已提前写入
+// 不传 handlers 时返回 ErrMethodNotAllowed, 交由 FuncErr 处理
+func (r *route) MethodNotAllowed(handlers ...any) Router {
+	if len(handlers) == 0 {
+		handlers = []any{func(x *X) error {
+			return ErrMethodNotAllowed
+		}}
+	}
+	return r.setHook(http.StatusMethodNotAllowed, handlers)
+}
+
+// PanicHandler 处理链中发生 panic 时先调用 fc, 返回的错误继续交由后续 FuncErr 处理
+// 未设置时沿 parent 继承
+func (r *route) PanicHandler(fc FuncErr) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	r.panicFc = fc
+	return r
+}
+
+func (r *route) setHook(code int, handlers []any) Router {
+	handlers = slices.Clone(handlers)
+	for i, fc := range handlers {
+		if tf, ok := adaptTyped(fc); ok {
+			handlers[i] = tf
+			continue
+		}
+		switch fc.(type) {
+		case FuncX2None, FuncX2Any, FuncX2Err, FuncX2AnyErr,
+			FuncAny2None, FuncAny2Any, FuncAny2Err, FuncAny2AnyErr,
+			FuncHttp2None, FuncHttp2Any, FuncHttp2Err, FuncHttp2AnyErr,
+			FuncErr, FuncSkipBefore:
+		default:
+			panic(fmt.Sprintf("not support handler %T", fc))
+		}
+	}
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	if r.hooks == nil {
+		r.hooks = make(map[int][]any)
+	}
+	r.hooks[code] = handlers
+	r.syncCache()
+	return r
+}
+
+// hookFallback 错误未被处理时输出原状态码, 处理链已写入响应时不再覆盖
+func hookFallback(code int) FuncErr {
+	return func(x *X, err error) error {
+		if !x.Written() {
+			x.WriteHeader(code)
+		}
+		return nil
+	}
+}
+
+// lookupHook 沿路径找到最深的节点, 返回它继承的 code 处理链, 见 syncCache
+func (r *route) lookupHook(u string, code int) (*route, []any) {
+	node := r
+	for u != "" && u != "/" {
+		seg, nexts := nextSegment(u)
+		next := node.subRouters[seg]
+		if next == nil {
+			for _, c := range node.colons {
+				if c.pattern.match(seg, nil) {
+					next = c
+					break
+				}
+			}
+		}
+		if next == nil {
+			next = node.wildcard
+		}
+		if next == nil {
+			break
+		}
+		node = next
+		u = nexts
+	}
+	// 只经过 get_subrouter 创建的中间节点没有自己的中间件, 也未生成缓存, 使用上级的
+	for ; node != nil; node = node.parent {
+		if node.hooksCache != nil {
+			if fcs := node.hooksCache[code]; fcs != nil {
+				return node, fcs
+			}
+			break
+		}
+	}
+	return nil, nil
+}
+
+func (r *route) serveHook(x *X, path string, code int) {
+	routeMu.RLock()
+	node, fcs := r.lookupHook(path, code)
+	routeMu.RUnlock()
+	if node != nil {
+		x.route = node
+		x.fcs = fcs
+		x.Next()
+		return
+	}
+	x.WriteHeader(code)
+}
+
+func (r *route) panicHandler() FuncErr {
+	routeMu.RLock()
+	defer routeMu.RUnlock()
+	for tr := r; tr != nil; tr = tr.parent {
+		if tr.panicFc != nil {
+			return tr.panicFc
+		}
+	}
+	return nil
+}
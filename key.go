@@ -0,0 +1,131 @@
+//
+// key.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"context"
+	"net/http"
+)
+
+// Key 请求内存储的类型化键, 以指针区分, 不同包的同名键不会冲突
+//
+//	var UserID = vigo.NewKey[string]("user_id")
+//	UserID.Set(x, "u1")
+//	id, ok := UserID.Get(x)
+type Key[T any] struct {
+	name string
+}
+
+func NewKey[T any](name string) *Key[T] {
+	return &Key[T]{name: name}
+}
+
+func (k *Key[T]) String() string {
+	return k.name
+}
+
+// Get 读取当前请求中的值, 未设置时返回零值和 false
+func (k *Key[T]) Get(x *X) (T, bool) {
+	if v, ok := x.value(k); ok {
+		t, ok := v.(T)
+		return t, ok
+	}
+	var zero T
+	return zero, false
+}
+
+func (k *Key[T]) Set(x *X, v T) {
+	x.setValue(k, v)
+}
+
+// Delete 删除当前请求中的值, 上游 context 中的同名值不受影响
+func (k *Key[T]) Delete(x *X) {
+	x.store.del(k)
+	x.ctx = nil
+}
+
+// store 单个请求的键值存储, 只在处理链中读写, 交给下游的是 storeCtx 中的副本
+type store struct {
+	kvs [][2]any
+}
+
+func (s *store) get(key any) (any, bool) {
+	if s == nil {
+		return nil, false
+	}
+	for i := len(s.kvs) - 1; i >= 0; i-- {
+		if s.kvs[i][0] == key {
+			return s.kvs[i][1], true
+		}
+	}
+	return nil, false
+}
+
+func (s *store) set(key, value any) {
+	for i := range s.kvs {
+		if s.kvs[i][0] == key {
+			s.kvs[i][1] = value
+			return
+		}
+	}
+	s.kvs = append(s.kvs, [2]any{key, value})
+}
+
+func (s *store) del(key any) {
+	if s == nil {
+		return
+	}
+	for i := range s.kvs {
+		if s.kvs[i][0] == key {
+			s.kvs = append(s.kvs[:i], s.kvs[i+1:]...)
+			return
+		}
+	}
+}
+
+// storeCtx 将 store 中的值暴露给下游库, 找不到时回退到原 context
+// 持有创建时的副本, 之后的写入不可见, 与 context.WithValue 一样可以在其它 goroutine 中安全读取
+type storeCtx struct {
+	context.Context
+	s store
+}
+
+func (c *storeCtx) Value(key any) any {
+	if v, ok := c.s.get(key); ok {
+		return v
+	}
+	return c.Context.Value(key)
+}
+
+func (x *X) value(key any) (any, bool) {
+	if v, ok := x.store.get(key); ok {
+		return v, true
+	}
+	if x.Request == nil {
+		return nil, false
+	}
+	v := x.Request.Context().Value(key)
+	return v, v != nil
+}
+
+func (x *X) setValue(key, value any) {
+	if x.store == nil {
+		x.store = &store{kvs: make([][2]any, 0, 4)}
+	}
+	x.store.set(key, value)
+	// 已交给下游的 context 保持不变, 下次 Context() 时重新复制
+	x.ctx = nil
+}
+
+// httpRequest 传给 http.Handler 的请求, 携带 store 中的值
+func (x *X) httpRequest() *http.Request {
+	if x.store == nil || x.Request == nil {
+		return x.Request
+	}
+	return x.Request.WithContext(x.Context())
+}
@@ -0,0 +1,170 @@
+//
+// lint.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+
+	"github.com/vyes-ai/vigo/logv"
+)
+
+func callSite(c [3]string) string {
+	if c[0] == "" {
+		return "unknown"
+	}
+	return c[0] + ":" + c[1]
+}
+
+// conflict 严格模式下 panic(ErrRouteConflict), 否则输出警告
+func (r *route) conflict(format string, a ...any) {
+	msg := fmt.Sprintf(format, a...)
+	if r.config().Strict {
+		panic(ErrRouteConflict.WithMessage("route conflict: " + msg))
+	}
+	logv.Warn().Msg(msg)
+}
+
+const (
+	// 同级有歧义的参数, 或未开启 Versioning 时被最新版本覆盖的旧版本
+	LintShadowed = "shadowed"
+	// 注册在通配符之下, 永远不会匹配的路由
+	LintUnreachable = "unreachable"
+	// SkipBefore 的位置会丢弃其他处理函数, 或者没有可跳过的中间件
+	LintSkipBefore = "skip-before"
+	// 处理函数没有描述
+	LintNoDesc = "no-desc"
+)
+
+// LintIssue Router.Lint 发现的问题
+type LintIssue struct {
+	Kind    string
+	Path    string
+	Method  string
+	Message string
+	// 注册的位置
+	File string
+	Line int
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s:%d: [%s] %s %s: %s", i.File, i.Line, i.Kind, i.Method, i.Path, i.Message)
+}
+
+// Lint 检查路由树中可能的问题, 可以在测试中使用
+//
+//	if issues := router.Lint(); len(issues) > 0 {
+//		t.Error(issues)
+//	}
+func (r *route) Lint() []LintIssue {
+	routeMu.RLock()
+	defer routeMu.RUnlock()
+	return r.lint(nil, false)
+}
+
+func (r *route) lint(res []LintIssue, unreachable bool) []LintIssue {
+	issue := func(tr *route, kind, m, msg string) {
+		i := LintIssue{Kind: kind, Path: tr.String(), Method: m, Message: msg}
+		if i.Path == "" {
+			i.Path = "/"
+		}
+		if c, ok := tr.handlersCaller[m]; ok {
+			i.File = c[0]
+			i.Line, _ = strconv.Atoi(c[1])
+		} else {
+			i.File = tr.caller[0]
+			i.Line, _ = strconv.Atoi(tr.caller[1])
+		}
+		res = append(res, i)
+	}
+	nodes := append([]*route{r}, r.sortedVersions()...)
+	for _, tr := range nodes {
+		for _, m := range allowedMethods {
+			fcs, ok := tr.handlers[m]
+			if !ok {
+				continue
+			}
+			if unreachable {
+				issue(tr, LintUnreachable, m, "routes under a wildcard are never matched")
+			}
+			if tr.handlersDesc[m] == "" {
+				issue(tr, LintNoDesc, m, "handler has no description")
+			}
+			if msg := tr.lintSkipBefore(fcs); msg != "" {
+				issue(tr, LintSkipBefore, m, msg)
+			}
+		}
+	}
+	for i, fc := range r.funcBefore {
+		if _, ok := fc.(FuncSkipBefore); ok && i > 0 {
+			issue(r, LintSkipBefore, "", "SkipBefore in UseBefore drops the middlewares registered before it on the same router")
+		}
+	}
+	if slices.ContainsFunc(r.funcAfter, func(fc any) bool { _, ok := fc.(FuncSkipBefore); return ok }) {
+		issue(r, LintSkipBefore, "", "SkipBefore in UseAfter drops the route handlers")
+	}
+	if len(r.versions) > 0 && r.config().Versioning == nil {
+		latest := map[string]Version{}
+		for _, vr := range r.sortedVersions() {
+			for m := range vr.handlers {
+				if v, ok := latest[m]; ok {
+					issue(vr, LintShadowed, m, fmt.Sprintf("version %s is shadowed by %s, versioning is not configured", vr.version, v))
+				} else {
+					latest[m] = vr.version
+				}
+			}
+		}
+		for m := range r.handlers {
+			if v, ok := latest[m]; ok {
+				issue(r, LintShadowed, m, fmt.Sprintf("unversioned handler is shadowed by %s, versioning is not configured", v))
+			}
+		}
+	}
+	for i, c := range r.colons {
+		for _, prev := range r.colons[:i] {
+			if prev.pattern.rank() == 0 && comparePattern(prev.pattern, c.pattern) == 0 {
+				issue(c, LintShadowed, "", fmt.Sprintf("may be shadowed by %s for segments both match", prev.String()))
+			}
+		}
+	}
+	keys := make([]string, 0, len(r.subRouters))
+	for k := range r.subRouters {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	// 通配符之后的片段不参与匹配
+	under := unreachable || (len(r.fragment) > 0 && r.fragment[0] == '*')
+	for _, k := range keys {
+		res = r.subRouters[k].lint(res, under)
+	}
+	for _, c := range r.colons {
+		res = c.lint(res, under)
+	}
+	if r.wildcard != nil {
+		res = r.wildcard.lint(res, under)
+	}
+	return res
+}
+
+// lintSkipBefore SkipBefore 应作为路由的第一个处理函数, 且上级有 before 中间件
+func (r *route) lintSkipBefore(fcs []any) string {
+	idx := slices.IndexFunc(fcs, func(fc any) bool { _, ok := fc.(FuncSkipBefore); return ok })
+	if idx < 0 {
+		return ""
+	}
+	if idx > 0 {
+		return "SkipBefore drops the handlers registered before it"
+	}
+	for tr := r; tr != nil; tr = tr.parent {
+		if len(tr.funcBefore) > 0 {
+			return ""
+		}
+	}
+	return "SkipBefore has no before middleware to skip"
+}
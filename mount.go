@@ -0,0 +1,59 @@
+//
+// mount.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Mount 将 prefix 及其下所有路径交给 h 处理, 所有方法都会转发
+// h 收到的 URL.Path 去掉了 prefix, 如 Mount("/debug", mux) 时 /debug/pprof/ => /pprof/
+// prefix 可以带参数, 父路由的 before/after 中间件照常执行
+func (r *route) Mount(prefix string, h http.Handler) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	desc := fmt.Sprintf("mount %T", h)
+	node := r.set(strings.TrimSuffix(prefix, "/"), "ANY", desc, func(x *X) {
+		serveMounted(x, h, "")
+	})
+	// 挂载点的末尾 / 交给 h 处理, 不做重定向
+	node.slash = 0
+	node.mount = fmt.Sprintf("%T", h)
+	node.set("*path", "ANY", desc, func(x *X) {
+		serveMounted(x, h, x.Params.Get("path"))
+	})
+	return node
+}
+
+func serveMounted(x *X, h http.Handler, rest string) {
+	req := x.Request.WithContext(x.Context())
+	req.URL = new(url.URL)
+	*req.URL = *x.Request.URL
+	req.URL.Path = "/" + strings.TrimPrefix(rest, "/")
+	req.URL.RawPath = mountedRawPath(x.Request.URL.RawPath, req.URL.Path)
+	h.ServeHTTP(x.ResponseWriter(), req)
+}
+
+// mountedRawPath 从原始 RawPath 中截取解码后等于 path 的后缀
+func mountedRawPath(raw, path string) string {
+	if raw == "" {
+		return ""
+	}
+	for i := len(raw) - 1; i >= 0; i-- {
+		if raw[i] != '/' {
+			continue
+		}
+		if p, err := url.PathUnescape(raw[i:]); err == nil && p == path {
+			return raw[i:]
+		}
+	}
+	return ""
+}
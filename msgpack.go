@@ -0,0 +1,235 @@
+//
+// msgpack.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"bufio"
+	"encoding"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"math"
+	"reflect"
+	"slices"
+	"strings"
+)
+
+// encodeMsgPack MessagePack 编码, 结构体编码为 map, 字段名规则同 json 标签
+// 实现了 encoding.TextMarshaler 的类型 (如 time.Time) 编码为字符串
+func encodeMsgPack(w io.Writer, data any) error {
+	bw := bufio.NewWriter(w)
+	e := &msgpackEncoder{w: bw}
+	e.encode(reflect.ValueOf(data))
+	if e.err != nil {
+		return e.err
+	}
+	return bw.Flush()
+}
+
+type msgpackEncoder struct {
+	w   *bufio.Writer
+	buf [9]byte
+	err error
+}
+
+var textMarshalerType = reflect.TypeFor[encoding.TextMarshaler]()
+
+func (e *msgpackEncoder) write(p []byte) {
+	if e.err == nil {
+		_, e.err = e.w.Write(p)
+	}
+}
+
+// head 写入类型字节和 n 字节的大端长度或数值
+func (e *msgpackEncoder) head(code byte, v uint64, n int) {
+	e.buf[0] = code
+	switch n {
+	case 1:
+		e.buf[1] = byte(v)
+	case 2:
+		binary.BigEndian.PutUint16(e.buf[1:], uint16(v))
+	case 4:
+		binary.BigEndian.PutUint32(e.buf[1:], uint32(v))
+	case 8:
+		binary.BigEndian.PutUint64(e.buf[1:], v)
+	}
+	e.write(e.buf[:1+n])
+}
+
+// length 按长度选择 fix/8/16/32 格式, fix 为 0 时没有 fix 格式
+func (e *msgpackEncoder) length(l int, fix byte, fixMax int, c8, c16, c32 byte) {
+	switch {
+	case l <= fixMax && fix != 0:
+		e.head(fix|byte(l), 0, 0)
+	case l <= math.MaxUint8 && c8 != 0:
+		e.head(c8, uint64(l), 1)
+	case l <= math.MaxUint16:
+		e.head(c16, uint64(l), 2)
+	default:
+		e.head(c32, uint64(l), 4)
+	}
+}
+
+func (e *msgpackEncoder) str(s string) {
+	e.length(len(s), 0xa0, 31, 0xd9, 0xda, 0xdb)
+	if e.err == nil {
+		_, e.err = e.w.WriteString(s)
+	}
+}
+
+func (e *msgpackEncoder) int(i int64) {
+	switch {
+	case i >= 0:
+		e.uint(uint64(i))
+	case i >= -32:
+		e.head(byte(i), 0, 0)
+	case i >= math.MinInt8:
+		e.head(0xd0, uint64(i), 1)
+	case i >= math.MinInt16:
+		e.head(0xd1, uint64(i), 2)
+	case i >= math.MinInt32:
+		e.head(0xd2, uint64(i), 4)
+	default:
+		e.head(0xd3, uint64(i), 8)
+	}
+}
+
+func (e *msgpackEncoder) uint(u uint64) {
+	switch {
+	case u <= 0x7f:
+		e.head(byte(u), 0, 0)
+	case u <= math.MaxUint8:
+		e.head(0xcc, u, 1)
+	case u <= math.MaxUint16:
+		e.head(0xcd, u, 2)
+	case u <= math.MaxUint32:
+		e.head(0xce, u, 4)
+	default:
+		e.head(0xcf, u, 8)
+	}
+}
+
+func (e *msgpackEncoder) encode(v reflect.Value) {
+	if e.err != nil {
+		return
+	}
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			e.head(0xc0, 0, 0)
+			return
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		e.head(0xc0, 0, 0)
+		return
+	}
+	if v.Type().Implements(textMarshalerType) {
+		b, err := v.Interface().(encoding.TextMarshaler).MarshalText()
+		if err != nil {
+			e.err = err
+			return
+		}
+		e.str(string(b))
+		return
+	}
+	switch v.Kind() {
+	case reflect.Bool:
+		if v.Bool() {
+			e.head(0xc3, 0, 0)
+		} else {
+			e.head(0xc2, 0, 0)
+		}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		e.int(v.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		e.uint(v.Uint())
+	case reflect.Float32:
+		e.head(0xca, uint64(math.Float32bits(float32(v.Float()))), 4)
+	case reflect.Float64:
+		e.head(0xcb, math.Float64bits(v.Float()), 8)
+	case reflect.String:
+		e.str(v.String())
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice && v.IsNil() {
+			e.head(0xc0, 0, 0)
+			return
+		}
+		if v.Type().Elem().Kind() == reflect.Uint8 {
+			b := make([]byte, v.Len())
+			reflect.Copy(reflect.ValueOf(b), v)
+			e.length(len(b), 0, 0, 0xc4, 0xc5, 0xc6)
+			e.write(b)
+			return
+		}
+		e.length(v.Len(), 0x90, 15, 0, 0xdc, 0xdd)
+		for i := 0; i < v.Len(); i++ {
+			e.encode(v.Index(i))
+		}
+	case reflect.Map:
+		if v.IsNil() {
+			e.head(0xc0, 0, 0)
+			return
+		}
+		keys := v.MapKeys()
+		if v.Type().Key().Kind() == reflect.String {
+			// 与 json 一致, 按键排序保证输出稳定
+			slices.SortFunc(keys, func(a, b reflect.Value) int {
+				return strings.Compare(a.String(), b.String())
+			})
+		}
+		e.length(len(keys), 0x80, 15, 0, 0xde, 0xdf)
+		for _, k := range keys {
+			e.encode(k)
+			e.encode(v.MapIndex(k))
+		}
+	case reflect.Struct:
+		names, idx := msgpackFields(v)
+		e.length(len(names), 0x80, 15, 0, 0xde, 0xdf)
+		for i, name := range names {
+			e.str(name)
+			f, _ := v.FieldByIndexErr(idx[i])
+			e.encode(f)
+		}
+	default:
+		e.err = fmt.Errorf("msgpack: unsupported type %s", v.Type())
+	}
+}
+
+// msgpackFields 需要输出的字段, 处理 json 标签的 omitempty
+func msgpackFields(v reflect.Value) ([]string, [][]int) {
+	var names []string
+	var idx [][]int
+	for _, f := range reflect.VisibleFields(v.Type()) {
+		if !f.IsExported() {
+			continue
+		}
+		tag := f.Tag.Get("json")
+		name, opts, _ := strings.Cut(tag, ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && isStruct(f.Type) {
+			continue
+		}
+		fv, err := v.FieldByIndexErr(f.Index)
+		if err != nil || !fv.CanInterface() {
+			// 嵌入的结构体指针为 nil, 或经过未导出的嵌入字段
+			continue
+		}
+		if strings.Contains(","+opts+",", ",omitempty,") && fv.IsZero() {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+		idx = append(idx, f.Index)
+	}
+	return names, idx
+}
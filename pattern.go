@@ -0,0 +1,318 @@
+//
+// pattern.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/vyes-ai/vigo/logv"
+)
+
+// 内置的参数类型约束, 如 /user/:id<int>
+var paramTypes = map[string]func(string) bool{
+	"int": func(s string) bool {
+		_, err := strconv.ParseInt(s, 10, 64)
+		return err == nil
+	},
+	"uint": func(s string) bool {
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err == nil
+	},
+	"float": func(s string) bool {
+		_, err := strconv.ParseFloat(s, 64)
+		return err == nil
+	},
+	"uuid":  regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`).MatchString,
+	"alpha": regexp.MustCompile(`^[a-zA-Z]+$`).MatchString,
+	"alnum": regexp.MustCompile(`^[a-zA-Z0-9]+$`).MatchString,
+	"hex":   regexp.MustCompile(`^[0-9a-fA-F]+$`).MatchString,
+}
+
+var paramNameRegex = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+
+// segParam 片段中的一个参数
+type segParam struct {
+	name       string
+	constraint string
+	check      func(string) bool
+	// 0: 无约束 1: 正则 2: 内置类型
+	rank int
+}
+
+func (p *segParam) valid(v string) bool {
+	return v != "" && (p.check == nil || p.check(v))
+}
+
+// segPattern 路径中的参数片段, 参数与字面量可以混合
+// :name             任意非空值
+// :name<int>        内置类型约束, 见 paramTypes
+// :name<[a-z]+>     正则约束, 需匹配整个值
+// v:name<\d+>       带字面量前缀
+// :name.:ext        多个参数, 相邻参数之间必须有字面量分隔
+// :id-:size.png     带字面量后缀
+// 参数值不能为空, 分隔字面量多次出现时优先取最后一处, 不满足约束时依次向前回退
+// 如 /files/:name.:ext 匹配 archive.tar.gz 得到 name=archive.tar ext=gz
+type segPattern struct {
+	// 参数前后的字面量, 比 params 多一个, 可为空串
+	lits   []string
+	params []segParam
+}
+
+func isNameByte(c byte) bool {
+	return c == '_' || c >= '0' && c <= '9' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+// parseSegment 解析路径片段, 非参数片段返回 nil
+func parseSegment(frag string) *segPattern {
+	if strings.IndexByte(frag, ':') < 0 {
+		return nil
+	}
+	p := &segPattern{}
+	lit := 0
+	for i := 0; i < len(frag); {
+		if frag[i] != ':' {
+			i++
+			continue
+		}
+		p.lits = append(p.lits, frag[lit:i])
+		logv.Assert(len(p.params) == 0 || i > lit, "adjacent params need a literal separator: "+frag)
+		j := i + 1
+		for j < len(frag) && isNameByte(frag[j]) {
+			j++
+		}
+		pa := segParam{name: frag[i+1 : j]}
+		logv.Assert(paramNameRegex.MatchString(pa.name), "invalid param name: "+frag)
+		for _, exist := range p.params {
+			logv.Assert(exist.name != pa.name, "duplicate param name: "+frag)
+		}
+		if j < len(frag) && frag[j] == '<' {
+			depth := 0
+			k := j
+			for ; k < len(frag); k++ {
+				if frag[k] == '<' {
+					depth++
+				} else if frag[k] == '>' {
+					depth--
+					if depth == 0 {
+						break
+					}
+				}
+			}
+			logv.Assert(k < len(frag), "invalid param constraint: "+frag)
+			pa.constraint = frag[j+1 : k]
+			logv.Assert(pa.constraint != "", "empty param constraint: "+frag)
+			j = k + 1
+		}
+		if pa.constraint != "" {
+			if fc, ok := paramTypes[pa.constraint]; ok {
+				pa.check = fc
+				pa.rank = 2
+			} else {
+				re, err := regexp.Compile("^(?:" + pa.constraint + ")$")
+				logv.AssertError(err, frag)
+				pa.check = re.MatchString
+				pa.rank = 1
+			}
+		}
+		p.params = append(p.params, pa)
+		i = j
+		lit = j
+	}
+	p.lits = append(p.lits, frag[lit:])
+	return p
+}
+
+// signature 忽略参数名, 相同签名的片段在同级只能存在一个
+func (p *segPattern) signature() string {
+	var sb strings.Builder
+	for i, pa := range p.params {
+		sb.WriteString(p.lits[i])
+		sb.WriteString("<" + pa.constraint + ">")
+	}
+	sb.WriteString(p.lits[len(p.params)])
+	return sb.String()
+}
+
+func (p *segPattern) names() []string {
+	res := make([]string, len(p.params))
+	for i := range p.params {
+		res[i] = p.params[i].name
+	}
+	return res
+}
+
+func (p *segPattern) literalLen() int {
+	l := 0
+	for _, s := range p.lits {
+		l += len(s)
+	}
+	return l
+}
+
+func (p *segPattern) rank() int {
+	r := 0
+	for _, pa := range p.params {
+		r += pa.rank
+	}
+	return r
+}
+
+// match 匹配整个片段, 成功时参数依次追加到 ps, ps 可为 nil
+func (p *segPattern) match(seg string, ps *Params) bool {
+	return p.matchSeg(seg, ps, false)
+}
+
+// fold 忽略字面量大小写匹配, 返回注册时的大小写形式
+func (p *segPattern) fold(seg string) (string, bool) {
+	ps := make(Params, 0, len(p.params))
+	if !p.matchSeg(seg, &ps, true) {
+		return "", false
+	}
+	vals := make([]string, len(ps))
+	for i := range ps {
+		vals[i] = ps[i][1]
+	}
+	return p.format(vals), true
+}
+
+// format 用参数值填充片段
+func (p *segPattern) format(vals []string) string {
+	var sb strings.Builder
+	for i, v := range vals {
+		sb.WriteString(p.lits[i])
+		sb.WriteString(v)
+	}
+	sb.WriteString(p.lits[len(vals)])
+	return sb.String()
+}
+
+func (p *segPattern) matchSeg(seg string, ps *Params, fold bool) bool {
+	if !hasPrefix(seg, p.lits[0], fold) {
+		return false
+	}
+	n := 0
+	if ps != nil {
+		n = len(*ps)
+	}
+	if p.matchParam(seg[len(p.lits[0]):], 0, ps, fold) {
+		return true
+	}
+	if ps != nil {
+		*ps = (*ps)[:n]
+	}
+	return false
+}
+
+func (p *segPattern) matchParam(s string, i int, ps *Params, fold bool) bool {
+	pa := &p.params[i]
+	lit := p.lits[i+1]
+	if i == len(p.params)-1 {
+		if len(s) < len(lit) || !hasPrefix(s[len(s)-len(lit):], lit, fold) {
+			return false
+		}
+		v := s[:len(s)-len(lit)]
+		if !pa.valid(v) {
+			return false
+		}
+		if ps != nil {
+			*ps = append(*ps, [2]string{pa.name, v})
+		}
+		return true
+	}
+	for end := lastIndex(s, lit, fold); end > 0; end = lastIndex(s[:end], lit, fold) {
+		v := s[:end]
+		if !pa.valid(v) {
+			continue
+		}
+		n := 0
+		if ps != nil {
+			n = len(*ps)
+			*ps = append(*ps, [2]string{pa.name, v})
+		}
+		if p.matchParam(s[end+len(lit):], i+1, ps, fold) {
+			return true
+		}
+		if ps != nil {
+			*ps = (*ps)[:n]
+		}
+	}
+	return false
+}
+
+func hasPrefix(s, prefix string, fold bool) bool {
+	if fold {
+		return len(s) >= len(prefix) && strings.EqualFold(s[:len(prefix)], prefix)
+	}
+	return strings.HasPrefix(s, prefix)
+}
+
+func lastIndex(s, sub string, fold bool) int {
+	if !fold {
+		return strings.LastIndex(s, sub)
+	}
+	for i := len(s) - len(sub); i >= 0; i-- {
+		if strings.EqualFold(s[i:i+len(sub)], sub) {
+			return i
+		}
+	}
+	return -1
+}
+
+// comparePattern 同级参数的匹配顺序:
+// 字面量越长越优先, 其次约束越强 (内置类型 > 正则 > 无约束, 多个参数时累加), 相同时按注册顺序
+func comparePattern(a, b *segPattern) int {
+	if la, lb := a.literalLen(), b.literalLen(); la != lb {
+		return lb - la
+	}
+	return b.rank() - a.rank()
+}
+
+func (r *route) findColon(p *segPattern) *route {
+	sig := p.signature()
+	for _, c := range r.colons {
+		if c.pattern.signature() == sig {
+			return c
+		}
+	}
+	return nil
+}
+
+func (r *route) addColon(c *route) {
+	r.colons = append(r.colons, c)
+	slices.SortStableFunc(r.colons, func(a, b *route) int {
+		return comparePattern(a.pattern, b.pattern)
+	})
+}
+
+// splitPath 按 / 切分路径, 忽略约束 <...> 内部的 /
+func splitPath(url string) []string {
+	url = strings.TrimPrefix(url, "/")
+	url = strings.TrimSuffix(url, "/")
+	res := make([]string, 0, 8)
+	depth := 0
+	start := 0
+	for i := 0; i < len(url); i++ {
+		switch url[i] {
+		case '<':
+			depth++
+		case '>':
+			if depth > 0 {
+				depth--
+			}
+		case '/':
+			if depth == 0 {
+				res = append(res, url[start:i])
+				start = i + 1
+			}
+		}
+	}
+	return append(res, url[start:])
+}
@@ -0,0 +1,224 @@
+//
+// proxy.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strings"
+)
+
+// parseProxies 解析 RestConf.TrustedProxies, 单个 IP 视为 /32 或 /128
+func parseProxies(list []string) ([]netip.Prefix, error) {
+	res := make([]netip.Prefix, 0, len(list))
+	for _, s := range list {
+		if p, err := netip.ParsePrefix(s); err == nil {
+			res = append(res, p.Masked())
+			continue
+		}
+		ip, err := netip.ParseAddr(s)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy: %s", s)
+		}
+		res = append(res, netip.PrefixFrom(ip.Unmap(), ip.Unmap().BitLen()))
+	}
+	return res, nil
+}
+
+// parseProxyHeader 校验 RestConf.ProxyHeader, 为空时使用 X-Forwarded-For
+func parseProxyHeader(h string) (string, error) {
+	if h == "" {
+		return "X-Forwarded-For", nil
+	}
+	h = http.CanonicalHeaderKey(h)
+	switch h {
+	case "X-Forwarded-For", "Forwarded", "X-Real-Ip":
+		return h, nil
+	}
+	return "", fmt.Errorf("invalid proxy header: %s", h)
+}
+
+func (app *Application) trusted(ip netip.Addr) bool {
+	if app == nil || !ip.IsValid() {
+		return false
+	}
+	ip = ip.Unmap()
+	for _, p := range app.proxies {
+		if p.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// hop 转发链中的一跳, 描述代理收到的连接
+type hop struct {
+	ip    netip.Addr
+	proto string
+	host  string
+}
+
+// client 从连接地址开始从右向左检查转发链, 返回第一个不可信的一跳, 即真实的客户端
+// 只有来自可信代理的转发头才会被使用, 且只读取 RestConf.ProxyHeader 指定的一种
+func (x *X) client() hop {
+	req := x.Request
+	res := hop{host: req.Host}
+	if req.TLS != nil {
+		res.proto = "https"
+	}
+	if ap, err := netip.ParseAddrPort(req.RemoteAddr); err == nil {
+		res.ip = ap.Addr().Unmap()
+	} else if ip, err := netip.ParseAddr(req.RemoteAddr); err == nil {
+		res.ip = ip.Unmap()
+	}
+	if !x.app.trusted(res.ip) {
+		return res
+	}
+	var hops []hop
+	switch x.app.proxyHeader {
+	case "Forwarded":
+		hops = parseForwarded(strings.Join(req.Header.Values("Forwarded"), ","))
+	case "X-Forwarded-For":
+		ips := splitList(strings.Join(req.Header.Values("X-Forwarded-For"), ","))
+		protos := splitList(req.Header.Get("X-Forwarded-Proto"))
+		hosts := splitList(req.Header.Get("X-Forwarded-Host"))
+		hops = make([]hop, len(ips))
+		for i, s := range ips {
+			hops[i].ip = parseNode(s)
+			hops[i].proto = listItem(protos, i, len(ips))
+			hops[i].host = listItem(hosts, i, len(ips))
+		}
+	case "X-Real-Ip":
+		if ip := parseNode(req.Header.Get("X-Real-IP")); ip.IsValid() {
+			hops = []hop{{ip: ip, proto: req.Header.Get("X-Forwarded-Proto"), host: req.Header.Get("X-Forwarded-Host")}}
+		}
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		h := hops[i]
+		if !h.ip.IsValid() {
+			// 无法识别的地址, 如 unknown 或隐藏的标识, 停在上一个可信代理
+			break
+		}
+		if h.proto != "" {
+			res.proto = strings.ToLower(h.proto)
+		}
+		if h.host != "" {
+			res.host = h.host
+		}
+		res.ip = h.ip
+		if !x.app.trusted(h.ip) {
+			break
+		}
+	}
+	return res
+}
+
+// listItem X-Forwarded-Proto/-Host 与 X-Forwarded-For 数量一致时按位置对应, 否则使用最后一个
+func listItem(list []string, i, n int) string {
+	if len(list) == n {
+		return list[i]
+	}
+	if i == n-1 && len(list) > 0 {
+		return list[len(list)-1]
+	}
+	return ""
+}
+
+func splitList(s string) []string {
+	if s == "" {
+		return nil
+	}
+	res := strings.Split(s, ",")
+	for i := range res {
+		res[i] = strings.TrimSpace(res[i])
+	}
+	return res
+}
+
+// parseNode 解析 for= 或 X-Forwarded-For 中的地址, 如 192.0.2.1, 192.0.2.1:80, [2001:db8::1]:80
+func parseNode(s string) netip.Addr {
+	s = strings.Trim(strings.TrimSpace(s), `"`)
+	if ip, err := netip.ParseAddr(s); err == nil {
+		return ip.Unmap()
+	}
+	if host, _, err := net.SplitHostPort(s); err == nil {
+		s = host
+	}
+	ip, _ := netip.ParseAddr(strings.Trim(s, "[]"))
+	return ip.Unmap()
+}
+
+// parseForwarded 解析 RFC 7239 Forwarded 头, 如
+// Forwarded: for=192.0.2.60;proto=https;host=example.com, for="[2001:db8:cafe::17]:4711"
+func parseForwarded(s string) []hop {
+	var res []hop
+	for _, elem := range splitQuoted(s, ',') {
+		var h hop
+		for _, pair := range splitQuoted(elem, ';') {
+			k, v, ok := strings.Cut(pair, "=")
+			if !ok {
+				continue
+			}
+			v = strings.Trim(strings.TrimSpace(v), `"`)
+			switch strings.ToLower(strings.TrimSpace(k)) {
+			case "for":
+				h.ip = parseNode(v)
+			case "proto":
+				h.proto = v
+			case "host":
+				h.host = v
+			}
+		}
+		res = append(res, h)
+	}
+	return res
+}
+
+// splitQuoted 按 sep 切分, 忽略引号内的分隔符
+func splitQuoted(s string, sep byte) []string {
+	var res []string
+	quoted := false
+	start := 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			quoted = !quoted
+		case sep:
+			if !quoted {
+				res = append(res, strings.TrimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(res, strings.TrimSpace(s[start:]))
+}
+
+// GetRemoteIP 客户端 IP, 只信任 RestConf.TrustedProxies 中的代理添加的转发头
+// 从右向左检查 Forwarded 或 X-Forwarded-For, 返回第一个不可信的地址
+func (x *X) GetRemoteIP() string {
+	ip := x.client().ip
+	if !ip.IsValid() {
+		return ""
+	}
+	return ip.String()
+}
+
+// Scheme 客户端请求的协议 http 或 https, 经过可信代理时使用 Forwarded proto= 或 X-Forwarded-Proto
+func (x *X) Scheme() string {
+	if p := x.client().proto; p == "https" || p == "http" {
+		return p
+	}
+	return "http"
+}
+
+// Host 客户端请求的主机, 经过可信代理时使用 Forwarded host= 或 X-Forwarded-Host
+func (x *X) Host() string {
+	return x.client().host
+}
@@ -0,0 +1,101 @@
+//
+// redirect.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"net/http"
+	"path"
+	"strings"
+)
+
+// redirect GET/HEAD 使用 301, 其余方法使用 308 以保留请求体
+func redirect(x *X, p string) {
+	if strings.HasPrefix(p, "//") {
+		// 避免被解析为其他域名
+		x.WriteHeader(http.StatusBadRequest)
+		return
+	}
+	code := http.StatusPermanentRedirect
+	if x.Request.Method == http.MethodGet || x.Request.Method == http.MethodHead {
+		code = http.StatusMovedPermanently
+	}
+	if x.Request.URL.RawQuery != "" {
+		p += "?" + x.Request.URL.RawQuery
+	}
+	x.Header().Set("Location", p)
+	x.WriteHeader(code)
+}
+
+// cleanPath 清理 . .. 和重复的 /, 保留末尾的 /
+func cleanPath(p string) string {
+	if p == "" {
+		return "/"
+	}
+	res := path.Clean("/" + p)
+	if p[len(p)-1] == '/' && res != "/" {
+		res += "/"
+	}
+	return res
+}
+
+// fixSlash 末尾 / 与注册形式不一致时返回修正后的路径
+func fixSlash(p string, slash int8) (string, bool) {
+	if p == "/" {
+		return "", false
+	}
+	has := p[len(p)-1] == '/'
+	if slash == 1 && has {
+		return strings.TrimRight(p, "/"), true
+	} else if slash == 2 && !has {
+		return p + "/", true
+	}
+	return "", false
+}
+
+// matchFold 忽略大小写查找路径, 返回注册时的大小写形式
+func (r *route) matchFold(u string, res []byte) ([]byte, bool) {
+	if u == "/" || u == "" {
+		if r.registered() {
+			return append(res, u...), true
+		}
+		if r.wildcard != nil && r.wildcard.registered() {
+			return append(res, u...), true
+		}
+		return nil, false
+	}
+	seg, nexts := nextSegment(u)
+	sep := ""
+	if len(seg) < len(u) {
+		sep = "/"
+	}
+	if subr := r.subRouters[seg]; subr != nil {
+		if p, ok := subr.matchFold(nexts, append(append(res, seg...), sep...)); ok {
+			return p, true
+		}
+	}
+	for k, subr := range r.subRouters {
+		if k != seg && strings.EqualFold(k, seg) {
+			if p, ok := subr.matchFold(nexts, append(append(res, k...), sep...)); ok {
+				return p, true
+			}
+		}
+	}
+	for _, c := range r.colons {
+		s, ok := c.pattern.fold(seg)
+		if !ok {
+			continue
+		}
+		if p, ok := c.matchFold(nexts, append(append(res, s...), sep...)); ok {
+			return p, true
+		}
+	}
+	if r.wildcard != nil && r.wildcard.registered() {
+		return append(res, u...), true
+	}
+	return nil, false
+}
@@ -0,0 +1,325 @@
+//
+// render.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"encoding"
+	"encoding/csv"
+	"encoding/json"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net/http"
+	"reflect"
+	"slices"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Renderer 处理结果的编码器, x.Render 根据 ?format= 或 Accept 头选择
+// 通过 WithRenderer 注册到 Router, 或通过 Application.SetRenderer 注册到整个应用
+type Renderer struct {
+	// ?format= 使用的名称, 如 json
+	Name string
+	// 响应的 Content-Type, 其中的媒体类型用于匹配 Accept
+	ContentType string
+	Encode      func(w io.Writer, data any) error
+}
+
+func (r *Renderer) mediaType() string {
+	mt, _, _ := strings.Cut(r.ContentType, ";")
+	return strings.TrimSpace(mt)
+}
+
+var (
+	RenderJSON = Renderer{Name: "json", ContentType: "application/json; charset=utf-8", Encode: func(w io.Writer, data any) error {
+		return json.NewEncoder(w).Encode(data)
+	}}
+	RenderXML = Renderer{Name: "xml", ContentType: "application/xml; charset=utf-8", Encode: func(w io.Writer, data any) error {
+		if _, err := io.WriteString(w, xml.Header); err != nil {
+			return err
+		}
+		return xml.NewEncoder(w).Encode(data)
+	}}
+	RenderYAML = Renderer{Name: "yaml", ContentType: "application/yaml; charset=utf-8", Encode: func(w io.Writer, data any) error {
+		enc := yaml.NewEncoder(w)
+		if err := enc.Encode(data); err != nil {
+			return err
+		}
+		return enc.Close()
+	}}
+	RenderCSV     = Renderer{Name: "csv", ContentType: "text/csv; charset=utf-8", Encode: encodeCSV}
+	RenderMsgPack = Renderer{Name: "msgpack", ContentType: "application/msgpack", Encode: encodeMsgPack}
+)
+
+// 未注册时使用的编码器, 第一个为默认
+var defaultRenderers = []Renderer{RenderJSON, RenderXML, RenderYAML, RenderCSV, RenderMsgPack}
+
+// WithRenderer 注册编码器, 同名时替换, 第一个注册的编码器作为默认
+func WithRenderer(rs ...Renderer) func(*RouterConf) {
+	return func(c *RouterConf) {
+		c.Renderers = mergeRenderers(slices.Clone(c.Renderers), rs)
+	}
+}
+
+// mergeRenderers 将 rs 中 dst 没有的编码器追加到 dst, 同名时替换
+func mergeRenderers(dst []Renderer, rs []Renderer) []Renderer {
+	for _, r := range rs {
+		if i := slices.IndexFunc(dst, func(d Renderer) bool { return d.Name == r.Name }); i >= 0 {
+			dst[i] = r
+		} else {
+			dst = append(dst, r)
+		}
+	}
+	return dst
+}
+
+// renderers 按 Router, Application, 内置的顺序合并, 同名时前者优先
+func (r *route) renderers(conf *RouterConf) []Renderer {
+	res := slices.Clone(conf.Renderers)
+	add := func(rs []Renderer) {
+		for _, tr := range rs {
+			if !slices.ContainsFunc(res, func(d Renderer) bool { return d.Name == tr.Name }) {
+				res = append(res, tr)
+			}
+		}
+	}
+	if app := r.root().app; app != nil {
+		add(app.renderers)
+	}
+	add(defaultRenderers)
+	return res
+}
+
+// negotiate 按 ?format= 和 Accept 选择编码器, 都没有指定时使用第一个
+// Accept 中的 application/vnd.xxx+json 按后缀匹配, 无法满足 Accept 时同样使用第一个
+func negotiate(req *http.Request, rs []Renderer) (*Renderer, error) {
+	if format := req.URL.Query().Get("format"); format != "" {
+		for i := range rs {
+			if rs[i].Name == format {
+				return &rs[i], nil
+			}
+		}
+		return nil, ErrNotAcceptable.WithMessage("not acceptable: " + format)
+	}
+	accept := req.Header.Get("Accept")
+	if accept == "" {
+		return &rs[0], nil
+	}
+	var best *Renderer
+	bestQ := 0.0
+	for _, part := range strings.Split(accept, ",") {
+		mt, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+		mt = strings.ToLower(strings.TrimSpace(mt))
+		q := 1.0
+		for _, p := range strings.Split(params, ";") {
+			if v, ok := strings.CutPrefix(strings.TrimSpace(p), "q="); ok {
+				q, _ = strconv.ParseFloat(v, 64)
+			}
+		}
+		if q <= bestQ {
+			continue
+		}
+		if i := slices.IndexFunc(rs, func(r Renderer) bool { return acceptMatch(mt, r.mediaType()) }); i >= 0 {
+			best, bestQ = &rs[i], q
+		}
+	}
+	if best == nil {
+		return &rs[0], nil
+	}
+	return best, nil
+}
+
+func acceptMatch(accept, mt string) bool {
+	if accept == "*/*" || accept == mt {
+		return true
+	}
+	typ, sub, _ := strings.Cut(accept, "/")
+	mtyp, msub, _ := strings.Cut(mt, "/")
+	if typ != mtyp {
+		return false
+	}
+	if sub == "*" {
+		return true
+	}
+	// application/vnd.myapp.v2+json
+	if _, suffix, ok := strings.Cut(sub, "+"); ok {
+		return suffix == msub
+	}
+	return false
+}
+
+// Render 按 ?format= 和 Accept 头选择编码器输出 data, 并设置 Content-Type 和 Vary
+// 处理链最后一个处理函数的返回值未被写入时, 同样通过 Render 输出, 字符串和数值除外, 见 X.JSON
+// data 为 *Result 时先写入其响应头和状态码, 没有 Data 时不选择编码器
+func (x *X) Render(data any) error {
+	if res, ok := data.(*Result); ok && res.Data == nil {
+		res.writeHeader(x)
+		return nil
+	}
+	rs := x.renderers
+	if len(rs) == 0 {
+		rs = defaultRenderers
+	}
+	r, err := negotiate(x.Request, rs)
+	if err != nil {
+		return err
+	}
+	return x.RenderWith(r, data)
+}
+
+// renderResult 输出处理链最后的返回值, 字符串和数值与 x.JSON 一样以 text/plain 直接输出
+func (x *X) renderResult(data any) error {
+	v := data
+	if res, ok := data.(*Result); ok {
+		v = res.Data
+	}
+	if _, raw := rawBytes(v); raw {
+		return x.JSON(data)
+	}
+	return x.Render(data)
+}
+
+// RenderWith 使用指定的编码器输出 data
+func (x *X) RenderWith(r *Renderer, data any) error {
+	res, ok := data.(*Result)
+	if ok && res.Data == nil {
+		res.writeHeader(x)
+		return nil
+	}
+	h := x.Header()
+	h.Set("Content-Type", r.ContentType)
+	h.Add("Vary", "Accept")
+	if ok {
+		res.writeHeader(x)
+		data = res.Data
+	}
+	if data == nil {
+		return nil
+	}
+	return r.Encode(x, data)
+}
+
+// encodeCSV 输出切片, 元素为结构体或 map 时第一行为列名, 元素为切片时直接作为一行
+func encodeCSV(w io.Writer, data any) error {
+	v := reflect.Indirect(reflect.ValueOf(data))
+	if v.Kind() != reflect.Slice && v.Kind() != reflect.Array {
+		return ErrNotAcceptable.WithMessage(fmt.Sprintf("not acceptable: csv: %T is not a slice", data))
+	}
+	cw := csv.NewWriter(w)
+	var header []string
+	var fields [][]int
+	for i := 0; i < v.Len(); i++ {
+		item := v.Index(i)
+		for (item.Kind() == reflect.Pointer || item.Kind() == reflect.Interface) && !item.IsNil() {
+			item = item.Elem()
+		}
+		var row []string
+		switch item.Kind() {
+		case reflect.Struct:
+			if header == nil {
+				header, fields = structColumns(item.Type())
+				if err := cw.Write(header); err != nil {
+					return err
+				}
+			}
+			row = make([]string, len(fields))
+			for j, idx := range fields {
+				if f, err := item.FieldByIndexErr(idx); err == nil {
+					row[j] = csvValue(f)
+				}
+			}
+		case reflect.Map:
+			if header == nil {
+				for _, k := range item.MapKeys() {
+					header = append(header, fmt.Sprint(k.Interface()))
+				}
+				slices.Sort(header)
+				if err := cw.Write(header); err != nil {
+					return err
+				}
+			}
+			row = make([]string, len(header))
+			for j, k := range header {
+				if mv := item.MapIndex(reflect.ValueOf(k).Convert(item.Type().Key())); mv.IsValid() {
+					row[j] = csvValue(mv)
+				}
+			}
+		case reflect.Slice, reflect.Array:
+			row = make([]string, item.Len())
+			for j := range row {
+				row[j] = csvValue(item.Index(j))
+			}
+		default:
+			row = []string{csvValue(item)}
+		}
+		if err := cw.Write(row); err != nil {
+			return err
+		}
+	}
+	cw.Flush()
+	return cw.Error()
+}
+
+// structColumns 导出字段的列名和下标, 列名规则同 json 标签, 匿名结构体字段展开
+func structColumns(t reflect.Type) ([]string, [][]int) {
+	var names []string
+	var idx [][]int
+	for _, f := range reflect.VisibleFields(t) {
+		if !f.IsExported() {
+			continue
+		}
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if name == "-" {
+			continue
+		}
+		if f.Anonymous && name == "" && isStruct(f.Type) {
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		names = append(names, name)
+		idx = append(idx, f.Index)
+	}
+	return names, idx
+}
+
+func isStruct(t reflect.Type) bool {
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t.Kind() == reflect.Struct
+}
+
+func csvValue(v reflect.Value) string {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() || !v.CanInterface() {
+		return ""
+	}
+	if tm, ok := v.Interface().(encoding.TextMarshaler); ok {
+		b, _ := tm.MarshalText()
+		return string(b)
+	}
+	switch v.Kind() {
+	case reflect.Map, reflect.Slice, reflect.Array, reflect.Struct:
+		if v.Kind() == reflect.Slice && v.Type().Elem().Kind() == reflect.Uint8 {
+			return string(v.Bytes())
+		}
+		b, _ := json.Marshal(v.Interface())
+		return string(b)
+	}
+	return fmt.Sprint(v.Interface())
+}
@@ -0,0 +1,76 @@
+//
+// result.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"maps"
+	"net/http"
+)
+
+// Result 带状态码和响应头的处理结果, 作为处理函数的返回值
+// 处理链末尾的 Render, x.JSON 和 common.JsonResponse 先写入响应头和状态码, 再输出 Data
+//
+//	return vigo.Created(user, "/users/"+user.ID), nil
+type Result struct {
+	// 为 0 时使用默认的 200
+	Status int
+	Header http.Header
+	// 为 nil 时没有响应体
+	Data any
+}
+
+// Created 201, location 不为空时写入 Location 头
+func Created(data any, location string) *Result {
+	res := &Result{Status: http.StatusCreated, Data: data}
+	if location != "" {
+		res.Header = http.Header{"Location": {location}}
+	}
+	return res
+}
+
+// Accepted 202, 请求已接受但尚未处理完成
+func Accepted(data any) *Result {
+	return &Result{Status: http.StatusAccepted, Data: data}
+}
+
+// NoContent 204, 没有响应体
+func NoContent() *Result {
+	return &Result{Status: http.StatusNoContent}
+}
+
+// Redirect 重定向到 url, code 不是 3xx 时使用 302
+func Redirect(url string, code int) *Result {
+	if code < 300 || code > 399 {
+		code = http.StatusFound
+	}
+	return &Result{Status: code, Header: http.Header{"Location": {url}}}
+}
+
+// WithHeaders 为 data 附加响应头, 同名时覆盖, data 为 *Result 时保留其状态码和响应头
+func WithHeaders(data any, h http.Header) *Result {
+	res := &Result{Header: make(http.Header, len(h)), Data: data}
+	if r, ok := data.(*Result); ok {
+		res.Status, res.Data = r.Status, r.Data
+		maps.Copy(res.Header, r.Header)
+	}
+	for k, vs := range h {
+		res.Header[http.CanonicalHeaderKey(k)] = vs
+	}
+	return res
+}
+
+// writeHeader 写入响应头, 有状态码时同时发送
+func (r *Result) writeHeader(x *X) {
+	h := x.Header()
+	for k, vs := range r.Header {
+		h[http.CanonicalHeaderKey(k)] = vs
+	}
+	if r.Status != 0 {
+		x.WriteHeader(r.Status)
+	}
+}
@@ -0,0 +1,158 @@
+//
+// reverse.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"fmt"
+	"net/url"
+	"slices"
+	"strings"
+)
+
+func (r *route) setName(method string, name RouteName) {
+	if name == "" {
+		delete(r.handlersName, method)
+		return
+	}
+	if exist := r.root().findName(name); exist != nil && exist != r {
+		var site [3]string
+		for m, n := range exist.handlersName {
+			if n == name {
+				site = exist.handlersCaller[m]
+			}
+		}
+		r.conflict("route name %s (%s) is already used by %s (%s)",
+			name, callSite(r.handlersCaller[method]), exist.String(), callSite(site))
+	}
+	if r.handlersName == nil {
+		r.handlersName = make(map[string]RouteName)
+	}
+	r.handlersName[method] = name
+}
+
+func (r *route) root() *route {
+	tr := r
+	for tr.parent != nil {
+		tr = tr.parent
+	}
+	return tr
+}
+
+func (r *route) findName(name RouteName) *route {
+	for _, n := range r.handlersName {
+		if n == name {
+			return r
+		}
+	}
+	for _, sub := range r.subRouters {
+		if res := sub.findName(name); res != nil {
+			return res
+		}
+	}
+	for _, c := range r.colons {
+		if res := c.findName(name); res != nil {
+			return res
+		}
+	}
+	for _, vr := range r.versions {
+		if res := vr.findName(name); res != nil {
+			return res
+		}
+	}
+	if r.wildcard != nil {
+		return r.wildcard.findName(name)
+	}
+	return nil
+}
+
+// URL 根据路由名称反向生成完整路径, 包含 Extend/SubRouter 添加的前缀
+// params 为 key, value 交替传入, 未出现在路径中的参数追加为 query
+// 如 r.URL("user.get", "id", 12, "tab", "info") => /user/12?tab=info
+func (r *route) URL(name string, params ...any) (string, error) {
+	routeMu.RLock()
+	defer routeMu.RUnlock()
+	target := r.root().findName(RouteName(name))
+	if target == nil {
+		return "", ErrNotFound.WithMessage("route not found: " + name)
+	}
+	return target.build(params)
+}
+
+func (r *route) build(params []any) (string, error) {
+	if len(params)%2 != 0 {
+		return "", ErrArgInvalid.WithArgs("params must be key value pairs")
+	}
+	values := make(map[string]string, len(params)/2)
+	keys := make([]string, 0, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		k := fmt.Sprint(params[i])
+		values[k] = fmt.Sprint(params[i+1])
+		keys = append(keys, k)
+	}
+	used := make(map[string]bool, len(keys))
+	frags := make([]string, 0, 8)
+	if r.version != "" {
+		r = r.parent
+	}
+	for tr := r; tr.parent != nil; tr = tr.parent {
+		frag := tr.fragment
+		if tr.pattern != nil {
+			vals := make([]string, len(tr.pattern.params))
+			for i, pa := range tr.pattern.params {
+				v, ok := values[pa.name]
+				if !ok {
+					return "", ErrArgMissing.WithArgs(pa.name)
+				}
+				if !pa.valid(v) {
+					return "", ErrArgInvalid.WithArgs(fmt.Sprintf("%s=%s not match %s", pa.name, v, tr.fragment))
+				}
+				used[pa.name] = true
+				vals[i] = v
+			}
+			frag = tr.pattern.format(vals)
+			// 参数值中含有分隔字面量时会被拆分到其他参数
+			ps := make(Params, 0, len(vals))
+			if !tr.pattern.match(frag, &ps) || !slices.EqualFunc(ps, vals, func(p [2]string, v string) bool { return p[1] == v }) {
+				return "", ErrArgInvalid.WithArgs(fmt.Sprintf("%s can not be built from %v", tr.fragment, vals))
+			}
+			frag = url.PathEscape(frag)
+		} else if frag[0] == '*' {
+			v := values[frag[1:]]
+			used[frag[1:]] = true
+			parts := strings.Split(strings.TrimPrefix(v, "/"), "/")
+			for i := range parts {
+				parts[i] = url.PathEscape(parts[i])
+			}
+			frag = strings.Join(parts, "/")
+		}
+		frags = append(frags, frag)
+	}
+	var sb strings.Builder
+	for i := len(frags) - 1; i >= 0; i-- {
+		if frags[i] == "" && i == 0 {
+			// 末尾通配符为空
+			continue
+		}
+		sb.WriteByte('/')
+		sb.WriteString(frags[i])
+	}
+	if sb.Len() == 0 {
+		sb.WriteByte('/')
+	}
+	query := url.Values{}
+	for _, k := range keys {
+		if !used[k] {
+			query.Add(k, values[k])
+		}
+	}
+	if len(query) > 0 {
+		sb.WriteByte('?')
+		sb.WriteString(query.Encode())
+	}
+	return sb.String(), nil
+}
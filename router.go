@@ -5,12 +5,16 @@
 package vigo
 
 import (
+	"context"
 	"fmt"
+	"maps"
 	"net/http"
 	"reflect"
 	"runtime"
 	"slices"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/vyes-ai/vigo/logv"
@@ -21,10 +25,18 @@ var allowedMethods = []string{
 	http.MethodPatch, http.MethodDelete, http.MethodConnect,
 	http.MethodOptions, http.MethodTrace, "PROPFIND", "ANY"}
 
-func NewRouter() Router {
+// routeMu 保护所有路由树的注册与修改, 运行中也可以安全地增删路由
+// 请求匹配只读取 table 快照, 不持锁; 未命中等低频路径持读锁遍历路由树
+var routeMu sync.RWMutex
+
+func NewRouter(opts ...func(*RouterConf)) Router {
 	r := &route{
 		funcBefore: make([]any, 0, 10),
 		funcAfter:  make([]any, 0, 10),
+		conf:       &RouterConf{},
+	}
+	for _, opt := range opts {
+		opt(r.conf)
 	}
 	return r
 }
@@ -34,7 +46,10 @@ type Router interface {
 	Print()
 	GetParamsList() []string
 	ServeHTTP(http.ResponseWriter, *http.Request)
+	Walk(fn func(RouteInfo) error) error
+	Lint() []LintIssue
 	SubRouter(prefix string) Router
+	Config(opts ...func(*RouterConf)) Router
 
 	Clear(url string, method string)
 	Set(url string, method string, handlers ...any) Router
@@ -48,8 +63,13 @@ type Router interface {
 
 	UseBefore(middleware ...any) Router
 	UseAfter(middleware ...any) Router
+	NotFound(handlers ...any) Router
+	MethodNotAllowed(handlers ...any) Router
+	PanicHandler(fc FuncErr) Router
 	Replace(Router) Router
 	Extend(string, Router) Router
+	Mount(prefix string, h http.Handler) Router
+	URL(name string, params ...any) (string, error)
 }
 
 type route struct {
@@ -60,16 +80,47 @@ type route struct {
 	handlers       map[string][]any
 	handlersCache  map[string][]any
 	handlersCaller map[string][3]string
-	handlersDesc   map[string][2]string
+	handlersDesc   map[string]string
+	handlersArgs   map[string]reflect.Type
+	handlersResp   map[string]reflect.Type
+	handlersMeta   map[string]Meta
+	handlersTime   map[string]time.Duration
+	handlersName   map[string]RouteName
 
 	parent *route
 
 	subRouters map[string]*route
-	colon      *route
+	colons     []*route
 	wildcard   *route
+	// 参数片段的匹配规则, 仅 colons 中的节点有效
+	pattern *segPattern
+	// 注册路径的末尾形式 0: 未指定 1: 无 / 2: 有 /
+	slash int8
+	// 仅 NewRouter 创建的节点非空, 其余节点沿 parent 继承
+	conf *RouterConf
+
+	// 未匹配时的处理链, key 为状态码 404/405
+	hooks      map[int][]any
+	hooksCache map[int][]any
+	panicFc    FuncErr
+
+	// Mount 挂载的 http.Handler 类型, 仅挂载点非空
+	mount string
+	// 创建节点的位置, 用于冲突提示
+	caller [3]string
+	// 同一路径不同版本的处理函数, 节点的 parent 为所在路径节点, 见 Version
+	versions map[Version]*route
+	version  Version
+
+	// 由 Application 创建或设置的根路由非空
+	app *Application
+	// 以该节点为根编译的匹配树, 变更时置空, 见 tree.go
+	table atomic.Pointer[table]
 }
 
 func (r *route) Print() {
+	routeMu.RLock()
+	defer routeMu.RUnlock()
 	fmt.Printf("Router Table\n%s\n", strings.Join(r.tree(""), "\n"))
 }
 
@@ -87,30 +138,46 @@ func (r *route) tree(root string) []string {
 		return res
 	}
 	res := make([]string, 0, 10)
-	if len(r.handlers) > 0 {
+	if r.registered() {
 		item := root
 		if item == "" {
 			item = "/"
 		}
 		item = "\033[32m" + item + "\033[0m"
+		if r.mount != "" {
+			item += " mount " + r.mount
+		}
 		for m := range r.handlers {
 			item += "\n    " + m
+			if name := r.handlersName[m]; name != "" {
+				item += fmt.Sprintf(" (%s)", name)
+			}
 			for _, h := range r.handlersCache[m] {
 				if des, ok := h.(string); ok {
 					item += fmt.Sprintf(" |des: %s|", des)
 					continue
 				}
-				op := reflect.ValueOf(h).Pointer()
-				fnName := strings.Split(runtime.FuncForPC(op).Name(), "/")
+				fnName := strings.Split(funcName(h), "/")
 				item += fmt.Sprintf(" %s", fnName[len(fnName)-1])
 			}
 		}
+		for _, vr := range r.sortedVersions() {
+			for m := range vr.handlers {
+				item += fmt.Sprintf("\n    %s @%s", m, vr.version)
+				for _, h := range vr.handlersCache[m] {
+					fnName := strings.Split(funcName(h), "/")
+					item += " " + fnName[len(fnName)-1]
+				}
+			}
+		}
 		res = append(res, item)
 	}
 	for _, subT := range r.subRouters {
 		res = fc(res, subT)
 	}
-	res = fc(res, r.colon)
+	for _, c := range r.colons {
+		res = fc(res, c)
+	}
 	res = fc(res, r.wildcard)
 	return res
 }
@@ -119,7 +186,11 @@ func (r *route) GetParamsList() []string {
 	var res []string
 	tr := r
 	for tr != nil {
-		if strings.HasPrefix(tr.fragment, ":") || strings.HasPrefix(tr.fragment, "*") {
+		if tr.pattern != nil {
+			for i := len(tr.pattern.params) - 1; i >= 0; i-- {
+				res = append(res, ":"+tr.pattern.params[i].name)
+			}
+		} else if strings.HasPrefix(tr.fragment, "*") {
 			res = append(res, tr.fragment)
 		}
 		tr = tr.parent
@@ -128,145 +199,276 @@ func (r *route) GetParamsList() []string {
 }
 
 func (r *route) String() string {
+	if r.version != "" {
+		return r.parent.String()
+	}
 	if r.parent != nil {
 		return r.parent.String() + "/" + r.fragment
 	}
 	return r.fragment
 }
 
-func (r *route) match(u string, m string, x *X) (*route, []any) {
-	if u == "/" || u == "" {
-		if len(r.handlers[m]) > 0 {
-			return r, r.handlersCache[m]
-		} else if len(r.handlers["ANY"]) > 0 {
-			return r, r.handlersCache["ANY"]
+func (r *route) Config(opts ...func(*RouterConf)) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	// 复制后替换, 已编译的 table 仍持有旧配置
+	c := *r.config()
+	for _, opt := range opts {
+		opt(&c)
+	}
+	r.conf = &c
+	r.syncCache()
+	return r
+}
+
+var defaultRouterConf = &RouterConf{}
+
+func (r *route) config() *RouterConf {
+	for tr := r; tr != nil; tr = tr.parent {
+		if tr.conf != nil {
+			return tr.conf
 		}
+	}
+	return defaultRouterConf
+}
+
+// nextSegment 切分出 u 的第一个路径片段
+func nextSegment(u string) (string, string) {
+	idx := strings.IndexByte(u, '/')
+	if idx < 0 {
+		return u, ""
+	}
+	return u[:idx], u[idx+1:]
+}
+
+// allowed 收集所有能匹配路径 u 的节点上已注册的方法
+func (r *route) allowed(u string, res []string) []string {
+	if u == "/" || u == "" {
+		res = r.appendMethods(res)
 		if r.wildcard != nil {
-			if len(r.wildcard.handlers[m]) > 0 {
-				x.setParam(r.wildcard.fragment[1:], "")
-				return r.wildcard, r.wildcard.handlersCache[m]
-			} else if len(r.wildcard.handlers["ANY"]) > 0 {
-				x.setParam(r.wildcard.fragment[1:], "")
-				return r.wildcard, r.wildcard.handlersCache["ANY"]
-			}
+			res = r.wildcard.appendMethods(res)
 		}
-		return nil, nil
+		return res
 	}
-	idx := 0
-	for i, v := range u {
-		if v == '/' {
-			break
-		} else {
-			idx = i + 1
+	seg, nexts := nextSegment(u)
+	if subr := r.subRouters[seg]; subr != nil {
+		res = subr.allowed(nexts, res)
+	}
+	for _, c := range r.colons {
+		if c.pattern.match(seg, nil) {
+			res = c.allowed(nexts, res)
 		}
 	}
-	nexts := u[idx:]
-	if len(nexts) > 0 && nexts[0] == '/' {
-		nexts = nexts[1:]
+	if r.wildcard != nil {
+		res = r.wildcard.appendMethods(res)
 	}
-	if subr := r.subRouters[u[:idx]]; subr != nil {
-		temp, fcs := subr.match(nexts, m, x)
-		if temp != nil {
-			return temp, fcs
+	return res
+}
+
+func (r *route) appendMethods(res []string) []string {
+	for m, fcs := range r.handlers {
+		if len(fcs) > 0 && m != "ANY" && !slices.Contains(res, m) {
+			res = append(res, m)
 		}
 	}
-	if r.colon != nil {
-		temp, fcs := r.colon.match(nexts, m, x)
-		if temp != nil {
-			x.setParam(r.colon.fragment[1:], u[:idx])
-			return temp, fcs
-		}
+	for _, vr := range r.versions {
+		res = vr.appendMethods(res)
 	}
-	if r.wildcard != nil {
-		if len(r.wildcard.handlers[m]) > 0 {
-			x.setParam(r.wildcard.fragment[1:], u)
-			return r.wildcard, r.wildcard.handlersCache[m]
-		} else if len(r.wildcard.handlers["ANY"]) > 0 {
-			x.setParam(r.wildcard.fragment[1:], u)
-			return r.wildcard, r.wildcard.handlersCache["ANY"]
+	return res
+}
+
+// allowHeader 生成 Allow 头, GET 隐含 HEAD
+func allowHeader(methods []string, autoOptions bool) string {
+	if slices.Contains(methods, http.MethodGet) {
+		methods = append(methods, http.MethodHead)
+	}
+	if autoOptions {
+		methods = append(methods, http.MethodOptions)
+	}
+	res := make([]string, 0, len(methods))
+	for _, m := range allowedMethods {
+		if slices.Contains(methods, m) {
+			res = append(res, m)
 		}
 	}
-	return nil, nil
+	return strings.Join(res, ", ")
 }
 
 func (r *route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	x := acquire()
 	defer release(x)
+	r.serve(w, req, x)
+}
+
+// serve x.Params 中可以带有域名参数
+func (r *route) serve(w http.ResponseWriter, req *http.Request, x *X) {
 	x.Request = req
-	x.writer = w
+	x.writer.reset(w)
+	defer x.finish()
 	start := time.Now()
 	_ = start
 
-	if subR, fcs := r.match(req.URL.Path[1:], req.Method, x); subR != nil && len(fcs) > 0 {
-
-		x.fcs = fcs
+	t := r.getTable()
+	conf := t.conf
+	x.renderers = t.renderers
+	x.app = t.app
+	if conf.CleanPath {
+		if p := cleanPath(req.URL.Path); p != req.URL.Path {
+			redirect(x, p)
+			return
+		}
+	}
+	path := req.URL.Path[1:]
+	var ver []int
+	var e *entry
+	m := methodIndex(req.Method)
+	if conf.Versioning != nil {
+		ver = conf.Versioning.requestVersion(req)
+		if pv, rest, ok := conf.Versioning.pathVersion(path); ok {
+			l := len(x.Params)
+			if e, m = t.matchHead(rest, m, x); e != nil && len(e.versions) > 0 {
+				ver, path = pv, rest
+			} else {
+				e, m = nil, methodIndex(req.Method)
+				x.Params = x.Params[:l]
+			}
+		}
+	}
+	if e == nil {
+		e, m = t.matchHead(path, m, x)
+	}
+	// HEAD 回退到 GET 时丢弃响应体
+	x.writer.discard = req.Method == http.MethodHead && m == methodGet
+	if e != nil && conf.RedirectTrailingSlash {
+		if p, ok := fixSlash(req.URL.Path, e.slash); ok {
+			redirect(x, p)
+			return
+		}
+	}
+	if e != nil {
+		ve, i := e.pick(m, ver)
+		if i < 0 {
+			// 请求的版本低于所有已注册的版本
+			r.serveHook(x, path, http.StatusNotFound)
+			return
+		}
+		x.route = ve.route
+		x.fcs = ve.handlers[i]
+		x.info = ve.infos[i]
+		x.renderers = ve.renderers
+		if ve.route.version != "" && conf.Versioning != nil {
+			conf.Versioning.retire(x, ve.route.version)
+		}
+		d := x.info.Timeout
+		if d == 0 {
+			d = conf.Timeout
+		}
+		if d > 0 {
+			ctx, cancel := context.WithTimeoutCause(req.Context(), d, conf.timeoutErr())
+			// 最先注册, 最后执行, OnFinish 中仍可以使用 x.Context()
+			x.OnFinish(func(*X) { cancel() })
+			x.Request = req.WithContext(ctx)
+		}
+		// 超时或客户端断开后处理函数不能再写入响应, 见 X.ctxErr
+		x.writer.ctx = x.Request.Context()
 		x.Next()
 		logv.WithNoCaller.Debug().Int("ms", int(time.Since(start).Milliseconds())).Str("method", req.Method).Msg(req.RequestURI)
+		return
+	}
+	routeMu.RLock()
+	var fold []byte
+	ok := false
+	if conf.CaseInsensitive {
+		fold, ok = r.matchFold(path, make([]byte, 0, len(req.URL.Path)))
+	}
+	methods := r.allowed(path, nil)
+	routeMu.RUnlock()
+	if ok && string(fold) != path {
+		redirect(x, req.URL.Path[:len(req.URL.Path)-len(path)]+string(fold))
+	} else if len(methods) > 0 {
+		x.Header().Set("Allow", allowHeader(methods, conf.AutoOptions))
+		if req.Method == http.MethodOptions && conf.AutoOptions {
+			x.WriteHeader(http.StatusNoContent)
+			return
+		}
+		r.serveHook(x, path, http.StatusMethodNotAllowed)
+		logv.WithNoCaller.Warn().Str("method", req.Method).Str("path", req.URL.Path).Msg("Method Not Allowed")
 	} else {
-		x.WriteHeader(404)
+		r.serveHook(x, path, http.StatusNotFound)
 		logv.WithNoCaller.Warn().Str("method", req.Method).Str("path", req.URL.Path).Msg("Not Handled")
 	}
 }
 
 func (r *route) get_subrouter(url string) *route {
+	if r.version != "" {
+		// 版本节点没有子路由
+		return r.parent.get_subrouter(url)
+	}
 	if url == "" || url == "/" {
 		return r
 	}
-	startIdx := 0
-	if url[0] == '/' {
-		url = url[1:]
-	}
-	if url[len(url)-1] != '/' {
-		url += "/"
-	}
 	var next *route
 	last := r
-	for i, c := range url {
-		if c == '/' {
-			next = &route{
-				fragment: url[startIdx:i],
-				parent:   last,
+	segs := splitPath(url)
+	caller := getCaller()
+	for i, frag := range segs {
+		next = &route{
+			fragment: frag,
+			parent:   last,
+			caller:   caller,
+		}
+		if next.fragment == "" {
+			logv.Assert(false, "url path can not has //")
+		} else if next.fragment[0] == '*' {
+			if i < len(segs)-1 {
+				r.conflict("segments after wildcard are ignored: %s (%s)", url, callSite(caller))
 			}
-			startIdx = i + 1
-			if next.fragment == "" {
-				logv.Assert(false, "url path can not has //")
-			} else if next.fragment[0] == '*' {
-				if last.wildcard != nil {
-					if last.wildcard.fragment != next.fragment {
-						logv.Warn().Msgf("variable path conflict: %s %s", last.colon.String(), next.String())
-					}
-					return last.wildcard
+			if last.wildcard != nil {
+				if last.wildcard.fragment != next.fragment {
+					r.conflict("wildcard conflict: %s (%s) is already registered at this level (%s), it is reused and the value is named %q",
+						next.String(), callSite(caller), callSite(last.wildcard.caller), last.wildcard.fragment[1:])
 				}
-				last.wildcard = next
-				return next
-			} else if next.fragment[0] == ':' {
-				if last.colon != nil {
-					if last.colon.fragment != next.fragment {
-						logv.Warn().Msgf("variable path conflict: %s %s", last.colon.String(), next.String())
-					}
-					last = last.colon
-				} else {
-					last.colon = next
-					last = next
-				}
-				continue
-			}
-
-			if last.subRouters == nil {
-				last.subRouters = make(map[string]*route)
+				return last.wildcard
 			}
-			if tmp := last.subRouters[next.fragment]; tmp != nil {
+			last.wildcard = next
+			return next
+		} else if p := parseSegment(next.fragment); p != nil {
+			next.pattern = p
+			if tmp := last.findColon(p); tmp != nil {
+				if tmp.fragment != next.fragment {
+					r.conflict("param name conflict: %s (%s) matches the same segments as %s (%s), params are named %v instead of %v",
+						next.String(), callSite(caller), tmp.String(), callSite(tmp.caller), tmp.pattern.names(), p.names())
+				}
 				last = tmp
 			} else {
-				last.subRouters[next.fragment] = next
+				for _, c := range last.colons {
+					if p.rank() == 0 && comparePattern(c.pattern, p) == 0 {
+						r.conflict("ambiguous params: %s (%s) and %s (%s) may match the same segment, %s is tried first",
+							c.String(), callSite(c.caller), next.String(), callSite(caller), c.String())
+					}
+				}
+				last.addColon(next)
 				last = next
 			}
+			continue
+		}
+
+		if last.subRouters == nil {
+			last.subRouters = make(map[string]*route)
+		}
+		if tmp := last.subRouters[next.fragment]; tmp != nil {
+			last = tmp
+		} else {
+			last.subRouters[next.fragment] = next
+			last = next
 		}
 	}
 	return last
 }
 
 func (r *route) Clear(prefix string, method string) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
 	var tmp *route
 	if len(r.fragment) > 0 && r.fragment[0] == '*' {
 		tmp = r
@@ -275,16 +477,26 @@ func (r *route) Clear(prefix string, method string) {
 	}
 	if method == "*" {
 		tmp.handlers = nil
+		tmp.versions = nil
 		tmp.subRouters = nil
 		tmp.funcAfter = nil
 		tmp.funcBefore = nil
 	} else {
 		delete(tmp.handlers, method)
+		for _, vr := range tmp.versions {
+			delete(vr.handlers, method)
+		}
 	}
 	tmp.syncCache()
 }
 
 func (r *route) Set(prefix string, method string, handlers ...any) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	return r.set(prefix, method, handlers...)
+}
+
+func (r *route) set(prefix string, method string, handlers ...any) *route {
 	method = strings.ToUpper(method)
 
 	logv.Assert(slices.Contains(allowedMethods, method), fmt.Sprintf("not support HTTP method: %v", method))
@@ -295,6 +507,22 @@ func (r *route) Set(prefix string, method string, handlers ...any) Router {
 		tmp = r
 	} else {
 		tmp = r.get_subrouter(prefix)
+		if len(prefix) > 1 && tmp.fragment[0] != '*' {
+			tmp.slash = 1
+			if prefix[len(prefix)-1] == '/' {
+				tmp.slash = 2
+			}
+		}
+	}
+	for _, fc := range handlers {
+		if v, ok := fc.(Version); ok && v != "" {
+			tmp = tmp.versionNode(v)
+		}
+	}
+	if tmp.handlers[method] != nil {
+		caller := getCaller()
+		tmp.conflict("duplicate route %s %s: registered at %s, registered again at %s",
+			method, tmp.String(), callSite(tmp.handlersCaller[method]), callSite(caller))
 	}
 	if tmp.handlers == nil {
 		tmp.handlers = make(map[string][]any)
@@ -303,10 +531,25 @@ func (r *route) Set(prefix string, method string, handlers ...any) Router {
 		tmp.handlersCaller = make(map[string][3]string)
 	}
 	if tmp.handlersDesc == nil {
-		tmp.handlersDesc = make(map[string][2]string)
+		tmp.handlersDesc = make(map[string]string)
+	}
+	if tmp.handlersArgs == nil {
+		tmp.handlersArgs = make(map[string]reflect.Type)
+	}
+	if tmp.handlersResp == nil {
+		tmp.handlersResp = make(map[string]reflect.Type)
+	}
+	if tmp.handlersMeta == nil {
+		tmp.handlersMeta = make(map[string]Meta)
+	}
+	if tmp.handlersTime == nil {
+		tmp.handlersTime = make(map[string]time.Duration)
 	}
 	var desc = ""
-	var desarg = ""
+	var name RouteName
+	var args, resp reflect.Type
+	var meta Meta
+	var timeout time.Duration
 	filterHandlers := make([]any, 0, len(handlers))
 	for _, fc := range handlers {
 		switch fc := fc.(type) {
@@ -317,29 +560,36 @@ func (r *route) Set(prefix string, method string, handlers ...any) Router {
 			filterHandlers = append(filterHandlers, fc)
 		case FuncDescription:
 			desc = fc
-		default:
-			fct := reflect.TypeOf(fc)
-			if fct.Kind() == reflect.Ptr {
-				fct = fct.Elem()
+		case RouteName:
+			name = fc
+		case Version:
+		case Meta:
+			if meta == nil {
+				meta = make(Meta, len(fc))
 			}
-			if fct.Kind() == reflect.Struct {
-				for i := 0; i < fct.NumField(); i++ {
-					field := fct.Field(i)
-					desarg += fmt.Sprintf("%s    %v    '%v'\n", field.Name, field.Type, field.Tag)
-				}
+			maps.Copy(meta, fc)
+		case Timeout:
+			timeout = time.Duration(fc)
+		default:
+			if tf, ok := adaptTyped(fc); ok {
+				// 多个 typed 处理函数时以最后一个为准
+				filterHandlers = append(filterHandlers, tf)
+				args, resp = tf.opts, tf.resp
+			} else if isStruct(reflect.TypeOf(fc)) {
+				args = reflect.TypeOf(fc)
 			} else {
-				logv.WithNoCaller.Fatal().Caller(2).Msgf("handler type not support: %T", fc)
+				logv.WithNoCaller.Fatal().Caller(3).Msgf("handler type not support: %T", fc)
 			}
 		}
 	}
-	tmp.handlersDesc[method] = [2]string{desc, desarg}
-	if tmp.handlers[method] != nil {
-		logv.Warn().Msgf("handler %s %s already exists", tmp.String(), method)
-		tmp.handlers[method] = filterHandlers
-	} else {
-		tmp.handlers[method] = filterHandlers
-	}
+	tmp.handlersDesc[method] = desc
+	tmp.handlersArgs[method] = args
+	tmp.handlersResp[method] = resp
+	tmp.handlersMeta[method] = meta
+	tmp.handlersTime[method] = timeout
+	tmp.handlers[method] = filterHandlers
 	tmp.handlersCaller[method] = getCaller()
+	tmp.setName(method, name)
 	tmp.syncCache()
 	return tmp
 }
@@ -383,13 +633,18 @@ func (r *route) Delete(url string, handlers ...any) Router {
 }
 
 func (r *route) UseAfter(middleware ...any) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
 	method := ""
 	for _, m := range middleware {
+		if tf, ok := adaptTyped(m); ok {
+			m = tf
+		}
 		switch m := m.(type) {
 		case FuncX2None, FuncX2Any, FuncX2Err, FuncX2AnyErr,
 			FuncAny2None, FuncAny2Any, FuncAny2Err, FuncAny2AnyErr,
 			FuncHttp2None, FuncHttp2Any, FuncHttp2Err, FuncHttp2AnyErr,
-			FuncErr, FuncSkipBefore:
+			FuncErr, FuncSkipBefore, *typedFunc:
 			if method == "" {
 				r.use(m, false)
 			} else {
@@ -413,13 +668,18 @@ func (r *route) UseAfter(middleware ...any) Router {
 }
 
 func (r *route) UseBefore(middleware ...any) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
 	method := ""
 	for _, m := range middleware {
+		if tf, ok := adaptTyped(m); ok {
+			m = tf
+		}
 		switch m := m.(type) {
 		case FuncX2None, FuncX2Any, FuncX2Err, FuncX2AnyErr,
 			FuncAny2None, FuncAny2Any, FuncAny2Err, FuncAny2AnyErr,
 			FuncHttp2None, FuncHttp2Any, FuncHttp2Err, FuncHttp2AnyErr,
-			FuncErr, FuncSkipBefore:
+			FuncErr, FuncSkipBefore, *typedFunc:
 			if method == "" {
 				r.use(m, true)
 			} else {
@@ -452,6 +712,7 @@ func (r *route) use(m any, before bool) {
 }
 
 func (r *route) syncCache() {
+	r.invalidate()
 	r.handlersCache = make(map[string][]any)
 	before := make([]any, 0, 10)
 	after := make([]any, 0, 10)
@@ -464,44 +725,70 @@ func (r *route) syncCache() {
 		tmpr = tmpr.parent
 	}
 	for k := range r.handlers {
-		r.handlersCache[k] = append(append([]any{}, before...), r.handlers[k]...)
-		r.handlersCache[k] = append(r.handlersCache[k], after...)
-		skipIdx := -1
-		for i := range r.handlersCache[k] {
-			if _, ok := r.handlersCache[k][i].(FuncSkipBefore); ok {
-				skipIdx = i
+		r.handlersCache[k] = buildChain(before, r.handlers[k], after)
+	}
+	// 继承最近的上级设置的处理链, 使用本节点的中间件
+	r.hooksCache = make(map[int][]any)
+	for tmpr := r; tmpr != nil; tmpr = tmpr.parent {
+		for code, fcs := range tmpr.hooks {
+			if _, ok := r.hooksCache[code]; !ok {
+				r.hooksCache[code] = append(buildChain(before, fcs, after), hookFallback(code))
 			}
 		}
-		if skipIdx >= 0 {
-			r.handlersCache[k] = append([]any{}, r.handlersCache[k][skipIdx+1:]...)
-		}
 	}
 
 	for _, sub := range r.subRouters {
 		sub.syncCache()
 	}
-	if r.colon != nil {
-		r.colon.syncCache()
+	for _, c := range r.colons {
+		c.syncCache()
+	}
+	for _, vr := range r.versions {
+		vr.syncCache()
 	}
 	if r.wildcard != nil {
 		r.wildcard.syncCache()
 	}
 }
 
+func buildChain(before, fcs, after []any) []any {
+	res := append(append([]any{}, before...), fcs...)
+	res = append(res, after...)
+	skipIdx := -1
+	for i := range res {
+		if _, ok := res[i].(FuncSkipBefore); ok {
+			skipIdx = i
+		}
+	}
+	if skipIdx >= 0 {
+		res = append([]any{}, res[skipIdx+1:]...)
+	}
+	return res
+}
+
 func (r *route) Extend(prefix string, subr Router) Router {
-	return r.get_subrouter(prefix).Replace(subr)
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	return r.get_subrouter(prefix).replace(subr)
 }
 func (r *route) Replace(subr Router) Router {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	return r.replace(subr)
+}
+
+func (r *route) replace(subr Router) Router {
 	// r.parent = parent.(*route)
 	logv.Assert(r.parent != nil, "root router can not replace")
 	name := r.fragment
 	sub := subr.(*route)
 	sub.fragment = name
 	sub.parent = r.parent
+	sub.pattern = r.pattern
 	if name[0] == '*' {
 		r.parent.wildcard = sub
-	} else if name[0] == ':' {
-		r.parent.colon = sub
+	} else if r.pattern != nil {
+		r.parent.colons[slices.Index(r.parent.colons, r)] = sub
 	} else {
 		r.parent.subRouters[name] = sub
 	}
@@ -511,50 +798,97 @@ func (r *route) Replace(subr Router) Router {
 
 func (r *route) SubRouter(prefix string) Router {
 	logv.Assert(prefix != "" && prefix != "/", "subrouter path can not be '' or '/'")
+	routeMu.Lock()
+	defer routeMu.Unlock()
 	return r.get_subrouter(prefix)
 }
 
 type rschema struct {
-	Tag      string              `json:"tag"`
-	Handlers []map[string]string `json:"handlers"`
-	Sub      []*rschema          `json:"sub"`
-	Full     string              `json:"full"`
+	Tag        string              `json:"tag"`
+	Handlers   []map[string]string `json:"handlers"`
+	Sub        []*rschema          `json:"sub"`
+	Full       string              `json:"full"`
+	Param      string              `json:"param,omitempty"`
+	Constraint string              `json:"constraint,omitempty"`
+	Mount      string              `json:"mount,omitempty"`
 }
 
 func (r *route) getSchema() *rschema {
 	resp := &rschema{
-		Tag:  r.fragment,
-		Full: r.String(),
+		Tag:   r.fragment,
+		Full:  r.String(),
+		Mount: r.mount,
+	}
+	if r.pattern != nil {
+		cons := make([]string, len(r.pattern.params))
+		for i, pa := range r.pattern.params {
+			cons[i] = pa.constraint
+		}
+		resp.Param = strings.Join(r.pattern.names(), ",")
+		resp.Constraint = strings.Join(cons, ",")
+	}
+	resp.Handlers = r.schemaHandlers(make([]map[string]string, 0, len(r.handlersCache)))
+	for _, vr := range r.sortedVersions() {
+		resp.Handlers = vr.schemaHandlers(resp.Handlers)
+	}
+	resp.Sub = make([]*rschema, 0, 10)
+	for _, sub := range r.subRouters {
+		resp.Sub = append(resp.Sub, sub.getSchema())
+	}
+	for _, c := range r.colons {
+		resp.Sub = append(resp.Sub, c.getSchema())
+	}
+	if r.wildcard != nil {
+		resp.Sub = append(resp.Sub, r.wildcard.getSchema())
+	}
+	return resp
+}
+
+// fieldsDesc 结构体的字段列表, 每行为 名称 类型 标签, 不是结构体时为空
+func fieldsDesc(t reflect.Type) string {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		return ""
 	}
-	resp.Handlers = make([]map[string]string, 0, len(r.handlersCache))
+	res := ""
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		res += fmt.Sprintf("%s    %v    '%v'\n", field.Name, field.Type, field.Tag)
+	}
+	return res
+}
+
+func (r *route) schemaHandlers(res []map[string]string) []map[string]string {
 	for m, fcs := range r.handlersCache {
 		fc := make(map[string]string)
-		fc["desc"] = r.handlersDesc[m][0]
-		fc["args"] = r.handlersDesc[m][1]
+		fc["desc"] = r.handlersDesc[m]
+		// args/resp 为字段列表, 类型名见 args_type/resp_type
+		if t := r.handlersArgs[m]; t != nil {
+			fc["args"] = fieldsDesc(t)
+			fc["args_type"] = t.String()
+		}
+		if t := r.handlersResp[m]; t != nil {
+			fc["resp"] = fieldsDesc(t)
+			fc["resp_type"] = t.String()
+		}
 		funcs := make([]string, 0, len(fcs))
 		for _, h := range fcs {
-			op := reflect.ValueOf(h).Pointer()
-			fullName := runtime.FuncForPC(op).Name()
-			funcs = append(funcs, fullName)
+			funcs = append(funcs, funcName(h))
 		}
 		fc["funcs"] = strings.Join(funcs, ",")
 		fc["method"] = m
+		fc["name"] = string(r.handlersName[m])
 		if info, ok := r.handlersCaller[m]; ok {
 			fc["file"] = info[0]
 			fc["line"] = info[1]
 			fc["caller"] = info[2]
 		}
-		resp.Handlers = append(resp.Handlers, fc)
-	}
-	resp.Sub = make([]*rschema, 0, 10)
-	for _, sub := range r.subRouters {
-		resp.Sub = append(resp.Sub, sub.getSchema())
-	}
-	if r.colon != nil {
-		resp.Sub = append(resp.Sub, r.colon.getSchema())
-	}
-	if r.wildcard != nil {
-		resp.Sub = append(resp.Sub, r.wildcard.getSchema())
+		if r.version != "" {
+			fc["version"] = string(r.version)
+		}
+		res = append(res, fc)
 	}
-	return resp
+	return res
 }
@@ -8,8 +8,22 @@
 package vigo
 
 import (
+	"context"
+	"embed"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
 	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/vyes-ai/vigo/logv"
 )
@@ -120,6 +134,104 @@ func BenchmarkRoute_GitHub_Param1(b *testing.B) {
 	}
 }
 
+func tableRouter() *route {
+	r := NewRouter().(*route)
+	for _, api := range githubAPi {
+		for _, m := range api.methods {
+			r.Set(api.path, m, func(x *X) any { return nil })
+		}
+	}
+	r.Get("/static/*path", func(x *X) any { return nil })
+	return r
+}
+
+// quietLog 关闭 Debug 级别的访问日志, 日志的格式化会分配内存, 不属于路由本身
+func quietLog(tb testing.TB) {
+	logv.SetLevel(logv.InfoLevel)
+	tb.Cleanup(func() { logv.SetLevel(logv.DebugLevel) })
+}
+
+func benchmarkServe(b *testing.B, method, path string) {
+	quietLog(b)
+	r := tableRouter()
+	req, _ := http.NewRequest(method, path, nil)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		r.ServeHTTP(w, req)
+	}
+}
+
+func BenchmarkRoute_GitHub_ServeStatic(b *testing.B) {
+	benchmarkServe(b, http.MethodGet, "/gitignore/templates")
+}
+
+func BenchmarkRoute_GitHub_ServeParam(b *testing.B) {
+	benchmarkServe(b, http.MethodGet, "/repos/vyes/vigo/commits/abc")
+}
+
+func BenchmarkRoute_GitHub_ServeWildcard(b *testing.B) {
+	benchmarkServe(b, http.MethodGet, "/static/js/app.js")
+}
+
+// ServeHTTP 完整的处理流程 (X, Params, writer, 处理链) 在匹配成功时不分配内存
+func TestRoute_ServeAllocs(t *testing.T) {
+	quietLog(t)
+	r := tableRouter()
+	for _, path := range []string{"/gitignore/templates", "/repos/vyes/vigo/commits/abc", "/static/js/app.js"} {
+		req, _ := http.NewRequest(http.MethodGet, path, nil)
+		if n := testing.AllocsPerRun(100, func() { r.ServeHTTP(w, req) }); n != 0 {
+			t.Errorf("%s: %v allocs", path, n)
+		}
+	}
+}
+
+func TestRoute_Table(t *testing.T) {
+	r := tableRouter()
+	x := acquire()
+	for _, api := range githubAPi {
+		segs := strings.Split(api.path, "/")
+		for i, s := range segs {
+			if strings.HasPrefix(s, ":") {
+				segs[i] = "v_" + s[1:]
+			}
+		}
+		path := strings.Join(segs, "/")
+		for _, m := range api.methods {
+			x.Params = x.Params[:0]
+			e := r.getTable().match(path[1:], methodIndex(m), x)
+			if e == nil {
+				t.Fatalf("%s %s: not match", m, api.path)
+			}
+			if got := e.route.String(); strings.TrimSuffix(got, "/") != strings.TrimSuffix(api.path, "/") {
+				t.Fatalf("%s %s: match %s", m, path, got)
+			}
+			for _, s := range strings.Split(api.path, "/") {
+				if strings.HasPrefix(s, ":") && x.Params.Get(s[1:]) != "v_"+s[1:] {
+					t.Fatalf("%s %s: param %s = %q", m, path, s[1:], x.Params.Get(s[1:]))
+				}
+			}
+		}
+	}
+	x.Params = x.Params[:0]
+	if e := r.getTable().match("static/js/app.js", methodGet, x); e == nil || x.Params.Get("path") != "js/app.js" {
+		t.Fatalf("wildcard: %v %v", e, x.Params)
+	}
+	if e := r.getTable().match("gitignore/templates", methodPost, x); e != nil {
+		t.Fatalf("method should not match")
+	}
+	// 注册新路由后匹配树重新生成
+	old := r.getTable()
+	r.Get("/gitignore/templates/:name", func(x *X) any { return nil })
+	if r.getTable() == old {
+		t.Fatalf("table not invalidated")
+	}
+	x.Params = x.Params[:0]
+	if e := r.getTable().match("gitignore/templates/go", methodGet, x); e == nil || x.Params.Get("name") != "go" {
+		t.Fatalf("new route not match")
+	}
+}
+
 // func TestRoute_ServeHTTP2(t *testing.T) {
 // 	w := new(fakeResponseWriter)
 // 	req, _ := http.NewRequest("GET", "/markdown/raw/?a=1", nil)
@@ -147,6 +259,1264 @@ func TestRoute_ServeHTTP(t *testing.T) {
 	}
 }
 
+func TestRoute_Constraint(t *testing.T) {
+	r := NewRouter()
+	r.Get("/user/:id<int>", func(x *X) any { return "int:" + x.Params.Get("id") })
+	r.Get("/user/:name", func(x *X) any { return "name:" + x.Params.Get("name") })
+	r.Get("/file/:slug<[a-z0-9-]+>", func(x *X) any { return "slug:" + x.Params.Get("slug") })
+	r.Get("/v:version<\\d+>/info", func(x *X) any { return "v:" + x.Params.Get("version") })
+	r.Get("/:page", func(x *X) any { return "page:" + x.Params.Get("page") })
+	r.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	cases := [][2]string{
+		{"/user/12", "int:12"},
+		{"/user/abc", "name:abc"},
+		{"/file/a-b-1", "slug:a-b-1"},
+		{"/file/A_B", ""},
+		{"/v2/info", "v:2"},
+		{"/vx/info", ""},
+		{"/about", "page:about"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c[0], nil))
+		if c[1] == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: expect 404, got %d", c[0], w.Code)
+			}
+			continue
+		}
+		if w.Body.String() != c[1] {
+			t.Errorf("%s: expect %s, got %s", c[0], c[1], w.Body.String())
+		}
+	}
+	// schema 和 Print 中包含参数名和约束
+	params := map[string][2]string{}
+	var walk func(s *rschema)
+	walk = func(s *rschema) {
+		if s.Param != "" {
+			params[s.Full] = [2]string{s.Param, s.Constraint}
+		}
+		for _, sub := range s.Sub {
+			walk(sub)
+		}
+	}
+	walk(r.(*route).getSchema())
+	expect := map[string][2]string{
+		"/user/:id<int>":          {"id", "int"},
+		"/user/:name":             {"name", ""},
+		"/file/:slug<[a-z0-9-]+>": {"slug", "[a-z0-9-]+"},
+		"/v:version<\\d+>":        {"version", `\d+`},
+		"/:page":                  {"page", ""},
+	}
+	for full, v := range expect {
+		if params[full] != v {
+			t.Errorf("schema %s: expect %v, got %v", full, v, params[full])
+		}
+	}
+	table := strings.Join(r.(*route).tree(""), "\n")
+	for _, frag := range []string{"/user/:id<int>", "/file/:slug<[a-z0-9-]+>", "/v:version<\\d+>/info"} {
+		if !strings.Contains(table, frag) {
+			t.Errorf("print: missing %s in\n%s", frag, table)
+		}
+	}
+}
+
+func TestRoute_MixedSegment(t *testing.T) {
+	r := NewRouter()
+	join := func(x *X) any {
+		res := make([]string, 0, len(x.Params))
+		for _, p := range x.Params {
+			res = append(res, p[0]+"="+p[1])
+		}
+		return strings.Join(res, ",")
+	}
+	r.Get("/files/:name.:ext", join)
+	r.Get("/files/:file", join)
+	r.Get("/@:user", join)
+	r.Get("/img/:id<int>-:size.png", join)
+	r.Get("/img/:id-:size.:ext<jpg|webp>", join)
+	r.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	cases := [][2]string{
+		{"/files/a.txt", "name=a,ext=txt"},
+		{"/files/archive.tar.gz", "name=archive.tar,ext=gz"},
+		{"/files/readme", "file=readme"},
+		{"/files/.env", "file=.env"},
+		{"/@veypi", "user=veypi"},
+		{"/@", ""},
+		{"/img/12-200.png", "id=12,size=200"},
+		{"/img/a-b-200.webp", "id=a-b,size=200,ext=webp"},
+		{"/img/12-200.gif", ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c[0], nil))
+		if c[1] == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s: expect 404, got %d", c[0], w.Code)
+			}
+			continue
+		}
+		if w.Body.String() != c[1] {
+			t.Errorf("%s: expect %s, got %s", c[0], c[1], w.Body.String())
+		}
+	}
+	r.Get("/d/:name.:ext", join, RouteName("download"))
+	if u, err := r.URL("download", "name", "a.b", "ext", "txt"); err != nil || u != "/d/a.b.txt" {
+		t.Errorf("url: %s %v", u, err)
+	}
+	if _, err := r.URL("download", "name", "a", "ext", "tar.gz"); err == nil {
+		t.Errorf("url: expect error for ambiguous value")
+	}
+}
+
+type walkArgs struct {
+	ID int `json:"id" parse:"path"`
+}
+
+func TestRoute_Walk(t *testing.T) {
+	r := NewRouter()
+	api := r.SubRouter("/api")
+	api.UseBefore(func(x *X) {})
+	api.Get("/user/:id<int>", "get user", &walkArgs{}, RouteName("user.get"), func(x *X) any { return nil })
+	api.Post("/user", func(x *X) any { return nil })
+	r.Get("/static/*path", func(x *X) any { return nil })
+	var infos []RouteInfo
+	err := r.Walk(func(info RouteInfo) error {
+		infos = append(infos, info)
+		return nil
+	})
+	if err != nil || len(infos) != 3 {
+		t.Fatalf("walk: %v %d", err, len(infos))
+	}
+	info := infos[1]
+	if info.Path != "/api/user/:id<int>" || info.Method != http.MethodGet || info.Name != "user.get" || info.Desc != "get user" {
+		t.Errorf("info: %+v", info)
+	}
+	if info.Args != reflect.TypeOf(&walkArgs{}) || !slices.Equal(info.Params, []string{"id"}) {
+		t.Errorf("args: %v %v", info.Args, info.Params)
+	}
+	if len(info.Handlers) != 1 || len(info.Chain) != 2 || info.File == "" || info.Line == 0 {
+		t.Errorf("handlers: %+v", info)
+	}
+	if infos[0].Path != "/api/user" || infos[2].Path != "/static/*path" || !slices.Equal(infos[2].Params, []string{"path"}) {
+		t.Errorf("order: %+v", infos)
+	}
+	stop := NewError("stop")
+	if err := r.Walk(func(info RouteInfo) error { return stop }); err != stop {
+		t.Errorf("walk should stop: %v", err)
+	}
+}
+
+func TestRoute_Meta(t *testing.T) {
+	r := NewRouter()
+	r.UseBefore(func(x *X) {
+		if perm, ok := x.Route().Meta("perm").(string); ok && x.Request.Header.Get("Perm") != perm {
+			x.WriteHeader(http.StatusForbidden)
+			x.Stop()
+		}
+	})
+	r.Get("/user", Meta{"perm": "user.read"}, Meta{"audit": "user"}, func(x *X) any { return "user" })
+	r.Get("/public", func(x *X) any { return "public" })
+	r.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	cases := []struct {
+		path, perm string
+		code       int
+	}{
+		{"/user", "user.read", http.StatusOK},
+		{"/user", "", http.StatusForbidden},
+		{"/public", "", http.StatusOK},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set("Perm", c.perm)
+		r.ServeHTTP(w, req)
+		if w.Code != c.code {
+			t.Errorf("%s %s: expect %d, got %d", c.path, c.perm, c.code, w.Code)
+		}
+	}
+	r.Walk(func(info RouteInfo) error {
+		if info.Path == "/user" && (info.Meta("perm") != "user.read" || info.Meta("audit") != "user") {
+			t.Errorf("walk meta: %v", info.Metadata)
+		}
+		return nil
+	})
+	var nilInfo *RouteInfo
+	if nilInfo.Meta("perm") != nil {
+		t.Errorf("nil route info")
+	}
+}
+
+type typedOpts struct {
+	Name  string `json:"name" parse:"query"`
+	Count *int   `json:"count" parse:"query"`
+}
+
+type typedResp struct {
+	Hello string `json:"hello"`
+}
+
+func typedHello(x *X, o *typedOpts) (*typedResp, error) {
+	return &typedResp{Hello: o.Name}, nil
+}
+
+func TestRoute_Typed(t *testing.T) {
+	r := NewRouter()
+	r.UseBefore(func(x *X, o *struct {
+		Token *string `parse:"header"`
+	}) (any, error) {
+		if o.Token == nil {
+			return nil, NewError("no token").WithCode(http.StatusUnauthorized)
+		}
+		return nil, nil
+	})
+	r.Get("/hello", "hello", typedHello)
+	r.Get("/value", func(x *X, o typedOpts) (typedResp, error) {
+		return typedResp{Hello: "v" + o.Name}, nil
+	})
+	r.UseAfter(func(x *X, err error) error {
+		x.WriteHeader(err.(*Error).Code)
+		return nil
+	})
+	cases := []struct {
+		path   string
+		token  bool
+		code   int
+		expect string
+	}{
+		{"/hello?name=vigo", true, 200, `{"hello":"vigo"}`},
+		{"/value?name=go", true, 200, `{"hello":"vgo"}`},
+		{"/hello?count=x", true, http.StatusConflict, ""},
+		{"/hello?name=vigo", false, http.StatusUnauthorized, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.token {
+			req.Header.Set("Token", "t")
+		}
+		r.ServeHTTP(w, req)
+		if w.Code != c.code || strings.TrimSpace(w.Body.String()) != c.expect {
+			t.Errorf("%s: expect %d %q, got %d %q", c.path, c.code, c.expect, w.Code, w.Body.String())
+		}
+	}
+	var infos []RouteInfo
+	r.Walk(func(ri RouteInfo) error {
+		infos = append(infos, ri)
+		return nil
+	})
+	if len(infos) != 2 {
+		t.Fatalf("expect 2 routes, got %d", len(infos))
+	}
+	hello := infos[0]
+	if hello.Desc != "hello" || hello.Args != reflect.TypeFor[*typedOpts]() || hello.Resp != reflect.TypeFor[*typedResp]() {
+		t.Errorf("unexpected route info: %+v", hello)
+	}
+	if !strings.HasSuffix(hello.Handlers[0], ".typedHello") || !strings.HasSuffix(hello.Chain[1], ".typedHello") {
+		t.Errorf("unexpected handler names: %v %v", hello.Handlers, hello.Chain)
+	}
+	if infos[1].Args != reflect.TypeFor[typedOpts]() || infos[1].Resp != reflect.TypeFor[typedResp]() {
+		t.Errorf("unexpected route info: %+v", infos[1])
+	}
+	// schema 中保留字段列表, 同时提供类型名
+	var schema map[string]string
+	for _, sub := range r.(*route).getSchema().Sub {
+		if sub.Full == "/hello" {
+			schema = sub.Handlers[0]
+		}
+	}
+	if schema["args"] != "Name    string    'json:\"name\" parse:\"query\"'\nCount    *int    'json:\"count\" parse:\"query\"'\n" ||
+		schema["args_type"] != "*vigo.typedOpts" || schema["resp"] != "Hello    string    'json:\"hello\"'\n" || schema["resp_type"] != "*vigo.typedResp" {
+		t.Errorf("unexpected schema: %v", schema)
+	}
+	// Print 中显示类型化处理函数的名称
+	if tree := strings.Join(r.(*route).tree(""), "\n"); !strings.Contains(tree, " vigo.typedHello ") {
+		t.Errorf("print typed handler: %s", tree)
+	}
+}
+
+func TestKey(t *testing.T) {
+	user := NewKey[string]("user")
+	other := NewKey[string]("user")
+	tenant := NewKey[int]("tenant")
+	r := NewRouter()
+	r.UseBefore(func(x *X) {
+		user.Set(x, x.Request.Header.Get("User"))
+		tenant.Set(x, 7)
+		x.Set("trace", "t1")
+	})
+	r.Get("/info", func(x *X) any {
+		u, ok := user.Get(x)
+		_, collide := other.Get(x)
+		tid, _ := tenant.Get(x)
+		ctx := x.Context()
+		return fmt.Sprintf("%s %v %v %d %v %v", u, ok, collide, tid, ctx.Value(user), ctx.Value("trace"))
+	})
+	r.Get("/http", func(w http.ResponseWriter, req *http.Request) {
+		fmt.Fprintf(w, "%v %v", req.Context().Value(tenant), req.Context().Value("trace"))
+	})
+	// Set 不修改 x.Request, 只能通过 x.Context() 读取
+	r.Get("/request", func(x *X) any {
+		return fmt.Sprintf("%v %v", x.Request.Context().Value("trace"), x.Context().Value("trace"))
+	})
+	// 交给其它 goroutine 的 context 是副本, 处理函数继续写入不会产生竞争, 需要 -race 验证
+	r.Get("/race", func(x *X) any {
+		ctx := x.Context()
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			for range 100 {
+				if ctx.Value(tenant) != 7 {
+					t.Errorf("snapshot changed: %v", ctx.Value(tenant))
+				}
+			}
+		}()
+		for i := range 100 {
+			tenant.Set(x, i)
+			x.Set(fmt.Sprint("k", i), i)
+		}
+		<-done
+		return fmt.Sprintf("%v %v", ctx.Value("k1"), x.Context().Value("k1"))
+	})
+	r.UseAfter(func(x *X, data any) {
+		if s, ok := data.(string); ok {
+			x.WriteHeader(http.StatusOK)
+			x.Write([]byte(s))
+		}
+	})
+	cases := []struct {
+		path, expect string
+	}{
+		{"/info", "u1 true false 7 u1 t1"},
+		{"/http", "7 t1"},
+		{"/race", "<nil> 1"},
+		{"/request", "<nil> t1"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set("User", "u1")
+		r.ServeHTTP(w, req)
+		if w.Body.String() != c.expect {
+			t.Errorf("%s: expect %q, got %q", c.path, c.expect, w.Body.String())
+		}
+	}
+	// 请求结束后 store 被清除
+	x := acquire()
+	x.Request = httptest.NewRequest(http.MethodGet, "/", nil)
+	if _, ok := user.Get(x); ok || x.Get("trace") != nil {
+		t.Errorf("store not reset")
+	}
+	release(x)
+}
+
+func TestX_Writer(t *testing.T) {
+	type state struct {
+		status  int
+		size    int64
+		written bool
+	}
+	var got state
+	r := NewRouter()
+	r.UseBefore(func(x *X) {
+		x.Next()
+		got = state{x.Status(), x.BytesWritten(), x.Written()}
+	})
+	r.Get("/none", func(x *X) {})
+	r.Get("/http", func(w http.ResponseWriter, req *http.Request) {
+		_, flush := w.(http.Flusher)
+		_, hijack := w.(http.Hijacker)
+		if !flush || !hijack {
+			t.Errorf("flusher %v, hijacker %v", flush, hijack)
+		}
+		w.WriteHeader(http.StatusCreated)
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("abc"))
+	})
+	r.Get("/copy", func(x *X) error {
+		_, err := io.Copy(x.ResponseWriter(), strings.NewReader("hello"))
+		return err
+	})
+	cases := []struct {
+		method, path string
+		code         int
+		expect       state
+	}{
+		{http.MethodGet, "/none", http.StatusOK, state{http.StatusOK, 0, false}},
+		{http.MethodGet, "/http", http.StatusCreated, state{http.StatusCreated, 3, true}},
+		{http.MethodGet, "/copy", http.StatusOK, state{http.StatusOK, 5, true}},
+		{http.MethodHead, "/copy", http.StatusOK, state{http.StatusOK, 0, true}},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || got != c.expect {
+			t.Errorf("%s %s: expect %d %v, got %d %v", c.method, c.path, c.code, c.expect, w.Code, got)
+		}
+	}
+}
+
+func TestX_OnFinish(t *testing.T) {
+	var logs []string
+	r := NewRouter()
+	r.UseBefore(func(x *X) {
+		x.OnFinish(func(x *X) {
+			logs = append(logs, fmt.Sprintf("first %d %v", x.Status(), x.Err()))
+		})
+		x.OnFinish(func(x *X) { panic("hook") })
+		x.OnFinish(func(x *X) { logs = append(logs, "last") })
+	})
+	r.Get("/ok", func(x *X) any { return nil })
+	r.Get("/err", func(x *X) error { return ErrNotFound })
+	r.Get("/panic", func(x *X) any { panic("boom") })
+	r.UseAfter(func(x *X, err error) error {
+		x.WriteHeader(http.StatusTeapot)
+		return nil
+	})
+	cases := []struct {
+		path   string
+		expect string
+	}{
+		{"/ok", "last,first 200 <nil>"},
+		{"/err", "last,first 418 code: 404, message: not found"},
+		{"/panic", "last,first 418 code: 400, message: crash: boom"},
+	}
+	for _, c := range cases {
+		logs = nil
+		r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, c.path, nil))
+		if got := strings.Join(logs, ","); got != c.expect {
+			t.Errorf("%s: expect %q, got %q", c.path, c.expect, got)
+		}
+	}
+}
+
+func TestRoute_Timeout(t *testing.T) {
+	errCode := func(x *X, err error) error {
+		if e, ok := err.(*Error); ok {
+			x.WriteHeader(e.Code)
+		}
+		return nil
+	}
+	r := NewRouter(WithTimeout(20 * time.Millisecond))
+	r.Get("/slow", func(x *X) error {
+		<-x.Context().Done()
+		_, err := x.Write([]byte("late"))
+		return err
+	})
+	r.Get("/chain", func(x *X) { time.Sleep(30 * time.Millisecond) }, func(x *X) {
+		t.Errorf("chain continued after timeout")
+	})
+	r.Get("/stream", Timeout(-1), func(x *X) {
+		time.Sleep(30 * time.Millisecond)
+		x.Write([]byte("ok"))
+	})
+	r.Get("/short", Timeout(time.Millisecond), func(x *X) {
+		time.Sleep(5 * time.Millisecond)
+		x.Write([]byte("late"))
+	})
+	r.UseAfter(errCode)
+	unavailable := NewRouter(WithTimeout(time.Millisecond, http.StatusServiceUnavailable))
+	unavailable.Get("/slow", func(x *X) { time.Sleep(5 * time.Millisecond) })
+	unavailable.UseAfter(errCode)
+	// 没有错误处理函数时只写入状态码
+	bare := NewRouter(WithTimeout(time.Millisecond, http.StatusServiceUnavailable))
+	bare.Get("/value", func(x *X) any {
+		time.Sleep(5 * time.Millisecond)
+		return "late"
+	})
+	bare.Get("/err", func(x *X) error {
+		<-x.Context().Done()
+		return x.Context().Err()
+	})
+	cases := []struct {
+		r    Router
+		path string
+		code int
+		body string
+	}{
+		{r, "/slow", http.StatusGatewayTimeout, ""},
+		{r, "/chain", http.StatusGatewayTimeout, ""},
+		{r, "/stream", http.StatusOK, "ok"},
+		{r, "/short", http.StatusGatewayTimeout, ""},
+		{unavailable, "/slow", http.StatusServiceUnavailable, ""},
+		{bare, "/value", http.StatusServiceUnavailable, ""},
+		{bare, "/err", http.StatusServiceUnavailable, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		c.r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, c.path, nil))
+		if w.Code != c.code || w.Body.String() != c.body {
+			t.Errorf("%s: expect %d %q, got %d %q", c.path, c.code, c.body, w.Code, w.Body.String())
+		}
+	}
+	// 客户端断开后不再继续处理
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/chain", nil).WithContext(ctx))
+	if w.Code != ErrCanceled.Code {
+		t.Errorf("canceled: got %d", w.Code)
+	}
+	r.Walk(func(info RouteInfo) error {
+		if info.Path == "/stream" && info.Timeout != -1 {
+			t.Errorf("walk timeout: %v", info.Timeout)
+		}
+		return nil
+	})
+}
+
+func TestX_Render(t *testing.T) {
+	type item struct {
+		ID   int    `json:"id" xml:"id"`
+		Name string `json:"name" xml:"name"`
+	}
+	list := func(x *X) (any, error) { return []item{{1, "a"}}, nil }
+	text := Renderer{Name: "text", ContentType: "text/plain; charset=utf-8", Encode: func(w io.Writer, data any) error {
+		_, err := fmt.Fprint(w, data)
+		return err
+	}}
+	app, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	app.SetRenderer(RenderJSON, text)
+	r := app.Router()
+	r.Get("/list", list)
+	r.Get("/json", func(x *X) error { return x.JSON(M{"a": 1}) })
+	r.Get("/json/text", func(x *X) error { return x.JSON("ok") })
+	r.Get("/json/num", func(x *X) error { return x.JSON(42) })
+	r.Get("/hello", func(x *X) any { return "hello" })
+	sub := NewRouter(WithRenderer(text))
+	sub.Get("/list", list)
+	r.Extend("/sub", sub)
+	// 之后的 FuncErr 不影响返回值的输出
+	withErr := NewRouter()
+	withErr.Get("/list", list)
+	withErr.UseAfter(func(x *X, err error) error { return err })
+	r.Extend("/err", withErr)
+	cases := []struct {
+		path, accept string
+		code         int
+		ctype, body  string
+	}{
+		{"/list", "", 200, "application/json; charset=utf-8", `[{"id":1,"name":"a"}]` + "\n"},
+		{"/list", "text/html, */*;q=0.8", 200, "application/json; charset=utf-8", `[{"id":1,"name":"a"}]` + "\n"},
+		{"/list", "application/vnd.app.v2+json", 200, "application/json; charset=utf-8", `[{"id":1,"name":"a"}]` + "\n"},
+		{"/list", "application/json;q=0.5, application/xml", 200, "application/xml; charset=utf-8", xml.Header + "<item><id>1</id><name>a</name></item>"},
+		{"/list", "text/csv", 200, "text/csv; charset=utf-8", "id,name\n1,a\n"},
+		{"/list?format=yaml", "text/csv", 200, "application/yaml; charset=utf-8", "- id: 1\n  name: a\n"},
+		{"/list?format=msgpack", "", 200, "application/msgpack", "\x91\x82\xa2id\x01\xa4name\xa1a"},
+		{"/list?format=text", "", 200, "text/plain; charset=utf-8", "[{1 a}]"},
+		{"/list?format=bogus", "", 406, "", ""},
+		{"/sub/list", "", 200, "text/plain; charset=utf-8", "[{1 a}]"},
+		{"/err/list", "", 200, "application/json; charset=utf-8", `[{"id":1,"name":"a"}]` + "\n"},
+		{"/json", "", 200, "application/json", `{"a":1}`},
+		{"/json/text", "", 200, "text/plain; charset=utf-8", "ok"},
+		{"/json/num", "", 200, "text/plain; charset=utf-8", "42"},
+		// 返回的字符串与 x.JSON 一致, 不编码为 JSON
+		{"/hello", "application/json", 200, "text/plain; charset=utf-8", "hello"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		if c.accept != "" {
+			req.Header.Set("Accept", c.accept)
+		}
+		app.ServeHTTP(w, req)
+		if w.Code != c.code || strings.Join(w.Header().Values("Content-Type"), ",") != c.ctype || w.Body.String() != c.body {
+			t.Errorf("%s %s: expect %d %q %q, got %d %q %q", c.path, c.accept, c.code, c.ctype, c.body, w.Code, w.Header().Values("Content-Type"), w.Body.String())
+		}
+	}
+}
+
+func TestX_Result(t *testing.T) {
+	r := NewRouter()
+	r.Post("/users", func(x *X) (any, error) {
+		return Created(M{"id": 1}, "/users/1"), nil
+	})
+	r.Post("/jobs", func(x *X) any { return Accepted(M{"job": "j1"}) })
+	r.Delete("/users/1", func(x *X) any { return NoContent() })
+	r.Get("/old", func(x *X) any { return Redirect("/new", http.StatusSeeOther) })
+	r.Get("/headers", func(x *X) any {
+		return WithHeaders(Created("ok", "/h/1"), http.Header{"x-id": {"1"}})
+	})
+	j := r.SubRouter("/json")
+	j.Post("/users", func(x *X) any { return Created(M{"id": 2}, "/users/2") })
+	j.Delete("/users/2", func(x *X) any { return NoContent() })
+	j.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	cases := []struct {
+		method, path string
+		code         int
+		header       map[string]string
+		body         string
+	}{
+		{http.MethodPost, "/users", http.StatusCreated, map[string]string{"Location": "/users/1", "Content-Type": "application/json; charset=utf-8"}, `{"id":1}`},
+		{http.MethodPost, "/jobs", http.StatusAccepted, nil, `{"job":"j1"}`},
+		{http.MethodDelete, "/users/1", http.StatusNoContent, map[string]string{"Content-Type": ""}, ""},
+		{http.MethodGet, "/old", http.StatusSeeOther, map[string]string{"Location": "/new"}, ""},
+		{http.MethodGet, "/headers", http.StatusCreated, map[string]string{"Location": "/h/1", "X-Id": "1", "Content-Type": "text/plain; charset=utf-8"}, "ok"},
+		{http.MethodPost, "/json/users", http.StatusCreated, map[string]string{"Location": "/users/2", "Content-Type": "application/json"}, `{"id":2}`},
+		{http.MethodDelete, "/json/users/2", http.StatusNoContent, nil, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || strings.TrimSpace(w.Body.String()) != c.body {
+			t.Errorf("%s %s: expect %d %q, got %d %q", c.method, c.path, c.code, c.body, w.Code, w.Body.String())
+		}
+		for k, v := range c.header {
+			if got := w.Header().Get(k); got != v {
+				t.Errorf("%s %s: expect %s %q, got %q", c.method, c.path, k, v, got)
+			}
+		}
+	}
+	if res := Redirect("/x", http.StatusOK); res.Status != http.StatusFound {
+		t.Errorf("expect 302 for invalid redirect code, got %d", res.Status)
+	}
+}
+
+func TestX_Cookie(t *testing.T) {
+	type session struct {
+		User string `json:"user"`
+	}
+	newApp := func(keys ...[]byte) *Application {
+		app, err := New(WithCookieKeys(keys...))
+		if err != nil {
+			t.Fatal(err)
+		}
+		app.Router().Get("/set", func(x *X) error {
+			x.SetCookie("plain", "p1", func(c *http.Cookie) { c.MaxAge = 60 })
+			if err := x.SetSignedCookie("signed", session{"u1"}); err != nil {
+				return err
+			}
+			return x.SetSecureCookie("secure", session{"u2"})
+		})
+		app.Router().Get("/get", func(x *X) any {
+			var args struct {
+				Plain string `parse:"cookie"`
+			}
+			var signed, secure session
+			errs := []error{x.Parse(&args), x.SignedCookie("signed", &signed), x.SecureCookie("secure", &secure)}
+			return fmt.Sprintf("%s %s %s %v", args.Plain, signed.User, secure.User, errors.Join(errs...) == nil)
+		})
+		return app
+	}
+	oldKey, newKey := []byte("old key"), []byte("new key")
+	w := httptest.NewRecorder()
+	newApp(oldKey).ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/set", nil))
+	cookies := w.Result().Cookies()
+	if len(cookies) != 3 || !cookies[0].HttpOnly || cookies[0].Secure || cookies[0].SameSite != http.SameSiteLaxMode || cookies[0].MaxAge != 60 {
+		t.Fatalf("cookies: %v", cookies)
+	}
+	if strings.Contains(cookies[2].Value, "u2") {
+		t.Errorf("secure cookie not encrypted: %s", cookies[2].Value)
+	}
+	get := func(app *Application, cookies []*http.Cookie) string {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/get", nil)
+		for _, c := range cookies {
+			req.AddCookie(c)
+		}
+		app.ServeHTTP(w, req)
+		return w.Body.String()
+	}
+	tampered := []*http.Cookie{cookies[0], {Name: "signed", Value: "eyJ1c2VyIjoidTMifQ." + strings.Split(cookies[1].Value, ".")[1]}, {Name: "secure", Value: cookies[1].Value}}
+	cases := []struct {
+		app     *Application
+		cookies []*http.Cookie
+		expect  string
+	}{
+		{newApp(newKey, oldKey), cookies, "p1 u1 u2 true"},
+		{newApp(newKey), cookies, "p1   false"},
+		{newApp(oldKey), tampered, "p1   false"},
+		{newApp(), cookies, "p1   false"},
+	}
+	for i, c := range cases {
+		if got := get(c.app, c.cookies); got != c.expect {
+			t.Errorf("case %d: expect %q, got %q", i, c.expect, got)
+		}
+	}
+	x := &X{Request: httptest.NewRequest(http.MethodGet, "/", nil), app: newApp(newKey)}
+	x.Request.AddCookie(tampered[1])
+	var s session
+	if e, ok := x.SignedCookie("signed", &s).(*Error); !ok || e.Code != http.StatusBadRequest || e.Message != "invalid cookie: signed" {
+		t.Errorf("invalid cookie error: %v", e)
+	}
+}
+
+//go:embed go.mod
+var testFS embed.FS
+
+func TestX_File(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "data.txt")
+	if err := os.WriteFile(path, []byte("0123456789"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	r := NewRouter()
+	r.Get("/file", func(x *X) error { return x.File(path) })
+	r.Get("/embed", func(x *X) error { return x.Embed(&testFS, "go.mod") })
+	serve := func(path string, header ...string) *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		for i := 0; i+1 < len(header); i += 2 {
+			req.Header.Set(header[i], header[i+1])
+		}
+		r.ServeHTTP(w, req)
+		return w
+	}
+	full := serve("/file")
+	etag := full.Header().Get("ETag")
+	if full.Code != 200 || full.Body.String() != "0123456789" || etag == "" || full.Header().Get("Content-Type") != "text/plain; charset=utf-8" {
+		t.Fatalf("file: %d %q %v", full.Code, full.Body.String(), full.Header())
+	}
+	cases := []struct {
+		header []string
+		code   int
+		body   string
+	}{
+		{[]string{"Range", "bytes=2-4"}, http.StatusPartialContent, "234"},
+		{[]string{"Range", "bytes=-3"}, http.StatusPartialContent, "789"},
+		{[]string{"Range", "bytes=20-"}, http.StatusRequestedRangeNotSatisfiable, ""},
+		{[]string{"If-None-Match", etag}, http.StatusNotModified, ""},
+		{[]string{"If-None-Match", `"other"`}, http.StatusOK, "0123456789"},
+		{[]string{"If-Modified-Since", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)}, http.StatusNotModified, ""},
+		{[]string{"Range", "bytes=2-4", "If-Range", `"other"`}, http.StatusOK, "0123456789"},
+	}
+	for _, c := range cases {
+		w := serve("/file", c.header...)
+		body := w.Body.String()
+		if w.Code == http.StatusRequestedRangeNotSatisfiable {
+			body = ""
+		}
+		if w.Code != c.code || body != c.body {
+			t.Errorf("%v: expect %d %q, got %d %q", c.header, c.code, c.body, w.Code, body)
+		}
+	}
+	multi := serve("/file", "Range", "bytes=0-1,5-6")
+	if multi.Code != http.StatusPartialContent || !strings.HasPrefix(multi.Header().Get("Content-Type"), "multipart/byteranges") ||
+		!strings.Contains(multi.Body.String(), "01") || !strings.Contains(multi.Body.String(), "56") {
+		t.Errorf("multipart: %d %v", multi.Code, multi.Header())
+	}
+	emb := serve("/embed")
+	etag = emb.Header().Get("ETag")
+	if emb.Code != 200 || !strings.HasPrefix(emb.Body.String(), "module ") || len(etag) != 34 {
+		t.Fatalf("embed: %d %q", emb.Code, etag)
+	}
+	if w := serve("/embed", "If-None-Match", etag); w.Code != http.StatusNotModified || serve("/embed").Header().Get("ETag") != etag {
+		t.Errorf("embed etag: %d", w.Code)
+	}
+}
+
+func TestX_RemoteIP(t *testing.T) {
+	app, err := New(WithTrustedProxies("10.0.0.0/8", "127.0.0.1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := func(x *X) {
+		fmt.Fprintf(x, "%s %s %s", x.GetRemoteIP(), x.Scheme(), x.Host())
+	}
+	app.Router().Get("/ip", echo)
+	fwd, err := New(WithTrustedProxies("10.0.0.0/8", "127.0.0.1"), WithProxyHeader("forwarded"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fwd.Router().Get("/ip", echo)
+	realIP, err := New(WithTrustedProxies("127.0.0.1"), WithProxyHeader("X-Real-IP"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	realIP.Router().Get("/ip", echo)
+	plain := NewRouter()
+	plain.Get("/ip", echo)
+	cases := []struct {
+		h      http.Handler
+		remote string
+		header []string
+		expect string
+	}{
+		{app, "1.2.3.4:1000", []string{"X-Forwarded-For", "5.5.5.5", "X-Forwarded-Proto", "https"}, "1.2.3.4 http example.com"},
+		{plain, "10.0.0.1:1000", []string{"X-Forwarded-For", "5.5.5.5"}, "10.0.0.1 http example.com"},
+		{app, "10.0.0.1:1000", []string{"X-Forwarded-For", "6.6.6.6, 5.5.5.5"}, "5.5.5.5 http example.com"},
+		{app, "10.0.0.1:1000", []string{"X-Forwarded-For", "5.5.5.5, 10.0.0.2", "X-Forwarded-Proto", "https", "X-Forwarded-Host", "api.example.com"}, "5.5.5.5 https api.example.com"},
+		{fwd, "127.0.0.1:1000", []string{"Forwarded", `for=192.0.2.60;proto=https;host=a.com, for="[2001:db8::17]:4711"`}, "2001:db8::17 http example.com"},
+		{fwd, "10.0.0.1:1000", []string{"Forwarded", `for=192.0.2.60;proto=https;host=a.com, for=10.0.0.5`, "X-Forwarded-For", "9.9.9.9"}, "192.0.2.60 https a.com"},
+		{fwd, "10.0.0.1:1000", []string{"Forwarded", "for=unknown"}, "10.0.0.1 http example.com"},
+		// 只读取配置的转发头, 客户端伪造的其它转发头不生效
+		{app, "10.0.0.1:1000", []string{"Forwarded", "for=1.1.1.1;proto=https", "X-Forwarded-For", "9.9.9.9"}, "9.9.9.9 http example.com"},
+		{app, "127.0.0.1:1000", []string{"Forwarded", "for=1.1.1.1"}, "127.0.0.1 http example.com"},
+		{app, "127.0.0.1:1000", []string{"X-Real-IP", "7.7.7.7"}, "127.0.0.1 http example.com"},
+		{realIP, "127.0.0.1:1000", []string{"X-Real-IP", "7.7.7.7", "X-Forwarded-For", "1.1.1.1"}, "7.7.7.7 http example.com"},
+	}
+	for i, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/ip", nil)
+		req.RemoteAddr = c.remote
+		for j := 0; j+1 < len(c.header); j += 2 {
+			req.Header.Set(c.header[j], c.header[j+1])
+		}
+		c.h.ServeHTTP(w, req)
+		if w.Body.String() != c.expect {
+			t.Errorf("case %d: expect %q, got %q", i, c.expect, w.Body.String())
+		}
+	}
+	if _, err := New(WithTrustedProxies("10.0.0.0/33")); err == nil {
+		t.Errorf("invalid proxy accepted")
+	}
+	if _, err := New(WithProxyHeader("X-Client-IP")); err == nil {
+		t.Errorf("invalid proxy header accepted")
+	}
+}
+
+func TestApp_Domain(t *testing.T) {
+	app, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo := func(tag string) func(x *X) any {
+		return func(x *X) any { return tag + ":" + x.Params.Get("tenant") + x.Params.Get("id") }
+	}
+	app.Router().Get("/", echo("main"))
+	app.Domain("api.example.com").Get("/", echo("api"))
+	app.Domain("api.example.com:8080").Get("/", echo("api8080"))
+	app.Domain(":tenant.example.com").Get("/:id", echo("tenant"))
+	app.Domain("*.example.com").Get("/", echo("wild"))
+	app.Domain("*.example.com").NotFound(func(x *X) any { return "wild404" })
+	app.Domain("t:id<int>.example.com").Get("/", echo("num"))
+	if app.Domain("API.example.com") != app.Domain("api.example.com") {
+		t.Errorf("same domain should return same router")
+	}
+	// 只有字面量转为小写, 参数名和约束保持不变
+	if h := parseHost("T:Code<[A-Z]+>.API.example.com"); h.raw != "t:Code<[A-Z]+>.api.example.com" ||
+		h.params[0].params[0].name != "Code" || h.params[0].params[0].constraint != "[A-Z]+" {
+		t.Errorf("parse host: got %s %+v", h.raw, h.params[0].params[0])
+	}
+	for _, r := range []Router{app.Router(), app.Domain("api.example.com"), app.Domain("api.example.com:8080"),
+		app.Domain(":tenant.example.com"), app.Domain("*.example.com"), app.Domain("t:id<int>.example.com")} {
+		r.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	}
+	cases := [][2]string{
+		{"api.example.com/", "api:"},
+		{"API.Example.com:80/", "api:"},
+		{"api.example.com:8080/", "api8080:"},
+		{"acme.example.com/12", "tenant:acme12"},
+		{"t12.example.com/", "num:12"},
+		{"a.b.example.com/", "wild:"},
+		{"a.b.example.com/none", "wild404"},
+		{"acme.example.com/", ""},
+		{"example.com/", "main:"},
+		{"evilexample.com/", "main:"},
+		{"acme.example.com.:9000/1", "tenant:acme1"},
+	}
+	for _, c := range cases {
+		host, path, _ := strings.Cut(c[0], "/")
+		req := httptest.NewRequest(http.MethodGet, "/"+path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		app.ServeHTTP(w, req)
+		if w.Body.String() != c[1] {
+			t.Errorf("%s: expect %s, got %d %s", c[0], c[1], w.Code, w.Body.String())
+		}
+	}
+	// Domain 路由与 SetMux 按注册顺序分发
+	ordered, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mux := func(tag string) func(http.ResponseWriter, *http.Request) func(http.ResponseWriter, *http.Request) {
+		return func(w http.ResponseWriter, r *http.Request) func(http.ResponseWriter, *http.Request) {
+			if r.URL.Path != "/"+tag {
+				return nil
+			}
+			return func(w http.ResponseWriter, r *http.Request) { w.Write([]byte(tag)) }
+		}
+	}
+	ordered.SetMux(mux("before"))
+	ordered.Domain("*.example.com").Any("/*", func(x *X) { x.Write([]byte("domain")) })
+	ordered.SetMux(mux("after"))
+	ordered.Router().Any("/*", func(x *X) { x.Write([]byte("main")) })
+	cases = [][2]string{
+		{"a.example.com/before", "before"},
+		{"a.example.com/after", "domain"},
+		{"example.com/after", "after"},
+		{"example.com/x", "main"},
+	}
+	for _, c := range cases {
+		host, path, _ := strings.Cut(c[0], "/")
+		req := httptest.NewRequest(http.MethodGet, "/"+path, nil)
+		req.Host = host
+		w := httptest.NewRecorder()
+		ordered.ServeHTTP(w, req)
+		if w.Body.String() != c[1] {
+			t.Errorf("order %s: expect %s, got %d %s", c[0], c[1], w.Code, w.Body.String())
+		}
+	}
+}
+
+func TestRoute_Mount(t *testing.T) {
+	r := NewRouter(WithRedirectTrailingSlash())
+	r.UseBefore(func(x *X) { x.Header().Set("Before", "1") })
+	h := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.Write([]byte(req.URL.Path + "|" + req.URL.RawPath + "|" + req.URL.EscapedPath()))
+	})
+	r.Mount("/debug", h)
+	r.Mount("/t/:tenant/files/", h)
+	cases := [][2]string{
+		{"/debug", "/||/"},
+		{"/debug/", "/||/"},
+		{"/debug/pprof/heap", "/pprof/heap||/pprof/heap"},
+		{"/debug/a%2Fb", "/a/b|/a%2Fb|/a%2Fb"},
+		{"/t/acme/files/x.txt", "/x.txt||/x.txt"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(http.MethodPost, c[0], nil))
+		if w.Body.String() != c[1] || w.Header().Get("Before") != "1" {
+			t.Errorf("%s: expect %s, got %d %s", c[0], c[1], w.Code, w.Body.String())
+		}
+	}
+	mounted := false
+	for _, sub := range r.(*route).getSchema().Sub {
+		mounted = mounted || sub.Mount == "http.HandlerFunc"
+	}
+	if !mounted {
+		t.Errorf("mount not in schema")
+	}
+}
+
+func TestRoute_Version(t *testing.T) {
+	sunset := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRouter(WithVersioning(Versioning{
+		Vendor:     "vigo",
+		PathPrefix: true,
+		Retired:    map[Version]Retirement{"v1": {Sunset: sunset, Link: "https://example.com/v2"}},
+	}))
+	r.Get("/user", func(x *X) any { return "v0" })
+	r.Get("/user", Version("v1"), func(x *X) any { return "v1" })
+	r.Get("/user", Version("v2"), func(x *X) any { return "v2:" + string(x.Route().Version) })
+	r.Get("/order", Version("v2"), func(x *X) any { return "order" })
+	// 没有带版本的路由时不去掉版本前缀
+	r.Get("/v2/info", func(x *X) any { return "info" })
+	r.Get("/v:n<\\d+>/x", func(x *X) any { return "x" + x.Params.Get("n") })
+	r.UseAfter(func(x *X, data any) error { return x.JSON(data) })
+	cases := []struct {
+		path, header, accept, body string
+	}{
+		{"/user", "", "", "v2:v2"},
+		{"/user", "v1", "", "v1"},
+		{"/user", "1.5", "", "v1"},
+		{"/user", "v0", "", "v0"},
+		{"/user", "v9", "", "v2:v2"},
+		{"/user", "", "application/vnd.vigo.v1+json", "v1"},
+		{"/user", "", "application/vnd.other.v1+json", "v2:v2"},
+		{"/v1/user", "", "", "v1"},
+		{"/order", "v1", "", ""},
+		{"/v2/info", "", "", "info"},
+		{"/v3/x", "", "", "x3"},
+		{"/v3/info", "", "", ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, c.path, nil)
+		req.Header.Set("Accept-Version", c.header)
+		req.Header.Set("Accept", c.accept)
+		r.ServeHTTP(w, req)
+		if c.body == "" {
+			if w.Code != http.StatusNotFound {
+				t.Errorf("%s %s: expect 404, got %d", c.path, c.header, w.Code)
+			}
+			continue
+		}
+		if w.Body.String() != c.body {
+			t.Errorf("%s %s %s: expect %s, got %s", c.path, c.header, c.accept, c.body, w.Body.String())
+		}
+		if (c.body == "v1") != (w.Header().Get("Sunset") != "") {
+			t.Errorf("%s %s: sunset %q", c.path, c.header, w.Header().Get("Sunset"))
+		}
+	}
+	w := httptest.NewRecorder()
+	req := httptest.NewRequest(http.MethodGet, "/user", nil)
+	req.Header.Set("Accept-Version", "v1")
+	r.ServeHTTP(w, req)
+	if w.Header().Get("Deprecation") != "true" || w.Header().Get("Sunset") != "Thu, 01 Jan 2026 00:00:00 GMT" {
+		t.Errorf("retire headers: %v", w.Header())
+	}
+	versions := 0
+	r.Walk(func(info RouteInfo) error {
+		if info.Path == "/user" && info.Version != "" {
+			versions++
+		}
+		return nil
+	})
+	if versions != 2 {
+		t.Errorf("walk versions: %d", versions)
+	}
+}
+
+func TestRoute_Strict(t *testing.T) {
+	conflict := func(fc func()) (msg string) {
+		defer func() {
+			if e, ok := recover().(*Error); ok {
+				msg = e.Message
+			}
+		}()
+		fc()
+		return ""
+	}
+	r := NewRouter(WithStrict())
+	h := func(x *X) any { return nil }
+	r.Get("/user/:id", h)
+	r.Get("/static/*path", h)
+	r.Get("/a", RouteName("a"), h)
+	cases := []struct {
+		fc     func()
+		expect string
+	}{
+		{func() { r.Get("/user/:id", h) }, "duplicate route GET /user/:id: registered at"},
+		{func() { r.Get("/user/:uid/info", h) }, "param name conflict: /user/:uid"},
+		{func() { r.Get("/static/*file", h) }, "wildcard conflict"},
+		{func() { r.Get("/files/*path/raw", h) }, "segments after wildcard"},
+		{func() { r.Get("/b", RouteName("a"), h) }, "route name a"},
+		{func() { r.Get("/user/:id", Version("v1"), h) }, ""},
+	}
+	for _, c := range cases {
+		msg := conflict(c.fc)
+		if !strings.Contains(msg, c.expect) || (c.expect != "" && (!strings.HasPrefix(msg, "route conflict: ") || !strings.Contains(msg, ".go:"))) {
+			t.Errorf("expect %q, got %q", c.expect, msg)
+		}
+	}
+	// 非严格模式只输出警告
+	nr := NewRouter()
+	nr.Get("/a", h)
+	nr.Get("/a", h)
+}
+
+func TestRoute_Lint(t *testing.T) {
+	r := NewRouter()
+	r.UseBefore(func(x *X) {})
+	r.Get("/ok", "ok", func(x *X) any { return nil })
+	r.Get("/nodesc", func(x *X) any { return nil })
+	r.Get("/skip", "skip", func(x *X) any { return nil }, SkipBefore, func(x *X) any { return nil })
+	r.Get("/files/:name.:ext", "file", func(x *X) any { return nil })
+	r.Get("/files/:name-:size", "size", func(x *X) any { return nil })
+	r.Get("/user", "user", func(x *X) any { return nil })
+	r.Get("/user", "user v2", Version("v2"), func(x *X) any { return nil })
+	sub := NewRouter()
+	sub.Get("/info", "info", func(x *X) any { return nil })
+	r.Extend("/static/*path", sub)
+	kinds := map[string]string{}
+	for _, i := range r.Lint() {
+		kinds[i.Path] = i.Kind
+		if i.File == "" {
+			t.Errorf("issue without file: %s", i)
+		}
+	}
+	expect := map[string]string{
+		"/nodesc":            LintNoDesc,
+		"/skip":              LintSkipBefore,
+		"/files/:name-:size": LintShadowed,
+		"/user":              LintShadowed,
+		"/static/*path/info": LintUnreachable,
+	}
+	if len(kinds) != len(expect) {
+		t.Errorf("lint: %v", kinds)
+	}
+	for p, k := range expect {
+		if kinds[p] != k {
+			t.Errorf("%s: expect %s, got %q", p, k, kinds[p])
+		}
+	}
+}
+
+func TestRoute_MethodNotAllowed(t *testing.T) {
+	r := NewRouter(WithAutoOptions())
+	r.Get("/users/:id", func(x *X) { x.Write([]byte("get")) })
+	r.Post("/users/new", func(x *X) { x.Write([]byte("new")) })
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		allow  string
+		body   string
+	}{
+		{http.MethodGet, "/users/1", 200, "", "get"},
+		{http.MethodHead, "/users/1", 200, "", ""},
+		{http.MethodDelete, "/users/1", 405, "GET, HEAD, OPTIONS", ""},
+		{http.MethodDelete, "/users/new", 405, "GET, HEAD, POST, OPTIONS", ""},
+		{http.MethodOptions, "/users/new", 204, "GET, HEAD, POST, OPTIONS", ""},
+		{http.MethodGet, "/posts", 404, "", ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || w.Header().Get("Allow") != c.allow || w.Body.String() != c.body {
+			t.Errorf("%s %s: got %d %q %q", c.method, c.path, w.Code, w.Header().Get("Allow"), w.Body.String())
+		}
+	}
+}
+
+func TestRoute_Hooks(t *testing.T) {
+	r := NewRouter()
+	r.UseBefore(func(x *X) { x.Header().Set("X-Before", "1") })
+	r.UseAfter(func(x *X, err error) error {
+		if e, ok := err.(*Error); ok {
+			x.WriteHeader(e.Code)
+			x.Write([]byte(e.Message))
+			return nil
+		}
+		return err
+	})
+	r.Get("/users/:id", func(x *X) { x.Write([]byte("get")) })
+	r.Get("/panic", func(x *X) { panic("boom") })
+	r.NotFound()
+	r.MethodNotAllowed()
+	r.PanicHandler(func(x *X, err error) error { return ErrInternalServer })
+	api := r.SubRouter("/api")
+	api.Get("/ping", func(x *X) { x.Write([]byte("pong")) })
+	api.NotFound(func(x *X) error { return NewError("api not found").WithCode(404) })
+	// 没有设置处理链的子路由使用根路由的处理链, 但经过自己的中间件
+	admin := r.SubRouter("/admin")
+	admin.UseBefore(func(x *X) { x.Header().Set("X-Admin", "1") })
+	admin.Get("/ping", func(x *X) { x.Write([]byte("pong")) })
+	r.Get("/deep/a/b", func(x *X) {})
+	cases := []struct {
+		method string
+		path   string
+		code   int
+		body   string
+	}{
+		{http.MethodGet, "/users/1", 200, "get"},
+		{http.MethodGet, "/posts", 404, "not found"},
+		{http.MethodPost, "/users/1", 405, "method not allowed"},
+		{http.MethodGet, "/api/pong", 404, "api not found"},
+		{http.MethodGet, "/panic", 500, "internal server error"},
+		{http.MethodGet, "/admin/none", 404, "not found"},
+		{http.MethodPost, "/admin/ping", 405, "method not allowed"},
+		{http.MethodGet, "/deep/x", 404, "not found"},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		r.ServeHTTP(w, httptest.NewRequest(c.method, c.path, nil))
+		if w.Code != c.code || w.Body.String() != c.body || w.Header().Get("X-Before") != "1" {
+			t.Errorf("%s %s: got %d %q", c.method, c.path, w.Code, w.Body.String())
+		}
+		if admin := strings.HasPrefix(c.path, "/admin/"); admin != (w.Header().Get("X-Admin") == "1") {
+			t.Errorf("%s %s: admin middleware %v", c.method, c.path, !admin)
+		}
+	}
+}
+
+func TestRoute_URL(t *testing.T) {
+	user := NewRouter()
+	user.Get("/:id<int>", RouteName("user.get"), func(x *X) {})
+	user.Get("/:id<int>/files/*path", RouteName("user.file"), func(x *X) {})
+	r := NewRouter()
+	r.Get("/", RouteName("index"), func(x *X) {})
+	r.Extend("/api/user", user)
+	r.SubRouter("/blog").Get("/:slug", RouteName("blog"), func(x *X) {})
+	cases := []struct {
+		name   string
+		params []any
+		url    string
+	}{
+		{"index", nil, "/"},
+		{"user.get", []any{"id", 12}, "/api/user/12"},
+		{"user.get", []any{"id", 12, "tab", "a b"}, "/api/user/12?tab=a+b"},
+		{"user.file", []any{"id", 1, "path", "a b/c.txt"}, "/api/user/1/files/a%20b/c.txt"},
+		{"blog", []any{"slug", "a/b"}, "/blog/a%2Fb"},
+		{"user.get", []any{"id", "abc"}, ""},
+		{"user.get", nil, ""},
+		{"none", nil, ""},
+	}
+	for _, c := range cases {
+		res, err := user.URL(c.name, c.params...)
+		if c.url == "" {
+			if err == nil {
+				t.Errorf("%s %v: expect error, got %s", c.name, c.params, res)
+			}
+			continue
+		}
+		if err != nil || res != c.url {
+			t.Errorf("%s %v: expect %s, got %s %v", c.name, c.params, c.url, res, err)
+		}
+	}
+}
+
+func TestRoute_Redirect(t *testing.T) {
+	r := NewRouter(WithRedirectTrailingSlash(), WithCleanPath(), WithCaseInsensitive())
+	r.Get("/users", func(x *X) { x.Write([]byte("users")) })
+	r.Post("/users/:id/", func(x *X) { x.Write([]byte("user")) })
+	r.Get("/static/*path", func(x *X) { x.Write([]byte("static")) })
+	r.Get("/Docs/:name", func(x *X) { x.Write([]byte("docs")) })
+	cases := []struct {
+		method   string
+		path     string
+		code     int
+		location string
+	}{
+		{http.MethodGet, "/users", 200, ""},
+		{http.MethodGet, "/users/?a=1", 301, "/users?a=1"},
+		{http.MethodPost, "/users/1", 308, "/users/1/"},
+		{http.MethodGet, "/static/a/", 200, ""},
+		{http.MethodGet, "//api/../users", 301, "/users"},
+		{http.MethodGet, "/docs/Intro", 301, "/Docs/Intro"},
+		{http.MethodGet, "/USERS", 301, "/users"},
+		{http.MethodGet, "/none", 404, ""},
+	}
+	for _, c := range cases {
+		w := httptest.NewRecorder()
+		req := httptest.NewRequest(c.method, "/", nil)
+		req.URL.Path, req.URL.RawQuery, _ = strings.Cut(c.path, "?")
+		r.ServeHTTP(w, req)
+		if w.Code != c.code || w.Header().Get("Location") != c.location {
+			t.Errorf("%s %s: got %d %q", c.method, c.path, w.Code, w.Header().Get("Location"))
+		}
+	}
+}
+
+func TestRoute_Concurrent(t *testing.T) {
+	r := NewRouter()
+	r.Get("/ping", func(x *X) any { return "pong" })
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-stop:
+					return
+				default:
+				}
+				w := httptest.NewRecorder()
+				r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/ping", nil))
+				if w.Code != http.StatusOK {
+					t.Errorf("ping: %d", w.Code)
+					return
+				}
+				r.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/tenant/1/info", nil))
+			}
+		}()
+	}
+	for i := 0; i < 100; i++ {
+		sub := r.SubRouter(fmt.Sprintf("/tenant/%d", i))
+		sub.Get("/info", func(x *X) any { return "info" })
+		sub.UseBefore(func(x *X) {})
+		if i%10 == 0 {
+			r.Clear(fmt.Sprintf("/tenant/%d", i/2), "*")
+			r.Config(WithAutoOptions())
+			r.NotFound()
+		}
+	}
+	close(stop)
+	wg.Wait()
+	w := httptest.NewRecorder()
+	r.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/tenant/99/info", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("runtime route: %d", w.Code)
+	}
+}
+
 var githubAPi = []struct {
 	path    string
 	methods []string
@@ -11,7 +11,9 @@ import (
 	"crypto/tls"
 	"net"
 	"net/http"
-	"strings"
+	"net/netip"
+	"slices"
+	"sync/atomic"
 
 	"github.com/vyes-ai/vigo/logv"
 	"golang.org/x/net/netutil"
@@ -28,10 +30,20 @@ func New(opts ...func(*RestConf)) (*Application, error) {
 	if err := c.IsValid(); err != nil {
 		return nil, err
 	}
+	proxies, err := parseProxies(c.TrustedProxies)
+	if err != nil {
+		return nil, err
+	}
+	proxyHeader, err := parseProxyHeader(c.ProxyHeader)
+	if err != nil {
+		return nil, err
+	}
 	app := &Application{
-		config: c,
-		router: NewRouter(),
+		config:      c,
+		proxies:     proxies,
+		proxyHeader: proxyHeader,
 	}
+	app.SetRouter(NewRouter())
 	app.server = &http.Server{
 		Addr:              c.Url(),
 		TLSConfig:         c.TlsCfg,
@@ -53,61 +65,156 @@ func New(opts ...func(*RestConf)) (*Application, error) {
 }
 
 type Application struct {
-	router   Router
+	router Router
+	// 按匹配顺序排列, 注册时整体替换
+	domains atomic.Pointer[[]*domainRouter]
+	// 第一次调用 Domain 时 muxs 的长度, Domain 路由整体在这个位置参与分发
+	domainAt int
 	muxs     []func(http.ResponseWriter, *http.Request) func(http.ResponseWriter, *http.Request)
 	config   *RestConf
 	server   *http.Server
 	listener net.Listener
+	// 所有路由共用的编码器, 优先级低于 Router 的 WithRenderer
+	renderers []Renderer
+	// RestConf.TrustedProxies 解析后的结果
+	proxies []netip.Prefix
+	// RestConf.ProxyHeader 规范化后的结果
+	proxyHeader string
+}
+
+// SetRenderer 注册应用内所有路由共用的编码器, 同名时替换, 见 X.Render
+func (app *Application) SetRenderer(rs ...Renderer) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	app.renderers = mergeRenderers(slices.Clone(app.renderers), rs)
+	if r, ok := app.router.(*route); ok {
+		r.invalidate()
+	}
+	if domains := app.domains.Load(); domains != nil {
+		for _, d := range *domains {
+			d.router.invalidate()
+		}
+	}
 }
 
 func (app *Application) SetMux(m func(w http.ResponseWriter, r *http.Request) func(http.ResponseWriter, *http.Request)) {
 	app.muxs = append(app.muxs, m)
 }
 
+// Domain 返回域名 d 专属的路由, 规则见 hostPattern, 相同规则返回同一个路由
+// 匹配到的请求只由该路由处理, 未匹配路径时使用该路由的 NotFound 处理链
 func (app *Application) Domain(d string) Router {
-	newNouter := NewRouter()
-	fc := func(w http.ResponseWriter, r *http.Request) func(http.ResponseWriter, *http.Request) {
-		if r.Host == d {
-			logv.Warn().Msg(r.Host)
-			return newNouter.ServeHTTP
-		}
-		return nil
-	}
-	if strings.HasPrefix(d, "*.") {
-		d = strings.Replace(d, "*.", "", 1)
-		fc = func(w http.ResponseWriter, r *http.Request) func(http.ResponseWriter, *http.Request) {
-			if strings.HasSuffix(r.Host, d) {
-				return newNouter.ServeHTTP
-			}
-			return nil
+	h := parseHost(d)
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	var domains []*domainRouter
+	if old := app.domains.Load(); old != nil {
+		domains = *old
+	} else {
+		app.domainAt = len(app.muxs)
+	}
+	for _, dr := range domains {
+		if dr.host.raw == h.raw {
+			return dr.router
 		}
 	}
-	app.muxs = append(app.muxs, fc)
-	return newNouter
+	dr := &domainRouter{host: h, router: NewRouter().(*route)}
+	dr.router.app = app
+	domains = append(slices.Clone(domains), dr)
+	slices.SortStableFunc(domains, func(a, b *domainRouter) int {
+		return compareHost(a.host, b.host)
+	})
+	app.domains.Store(&domains)
+	return dr.router
 }
 
+// ServeHTTP 按注册顺序交给 SetMux 注册的函数和 Domain 路由处理, 都未处理时交给主路由
+// 所有 Domain 路由作为一个整体, 位于第一次调用 Domain 时的位置, 之间按 compareHost 的顺序匹配
 func (app *Application) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	if len(app.muxs) == 0 {
-		app.router.ServeHTTP(w, r)
-		return
-	}
-	for _, fc := range app.muxs {
+	domains := app.domains.Load()
+	for i, fc := range app.muxs {
+		if domains != nil && i == app.domainAt && app.serveDomain(w, r, *domains) {
+			return
+		}
 		if tmp := fc(w, r); tmp != nil {
 			tmp(w, r)
 			return
 		}
 	}
+	if domains != nil && app.domainAt >= len(app.muxs) && app.serveDomain(w, r, *domains) {
+		return
+	}
 	app.router.ServeHTTP(w, r)
 }
 
+func (app *Application) serveDomain(w http.ResponseWriter, r *http.Request, domains []*domainRouter) bool {
+	host, port := requestHost(r)
+	x := acquire()
+	defer release(x)
+	for _, d := range domains {
+		if d.host.match(host, port, &x.Params) {
+			d.router.serve(w, r, x)
+			return true
+		}
+	}
+	return false
+}
+
 func (app *Application) Router() Router {
 	return app.router
 }
 
 func (app *Application) SetRouter(r Router) {
+	routeMu.Lock()
+	defer routeMu.Unlock()
+	if tr, ok := r.(*route); ok {
+		tr.app = app
+		tr.invalidate()
+	}
 	app.router = r
 }
 
+// URL 在主路由和所有 Domain 路由中查找路由名称并生成路径
+// 固定域名下的路由返回 //host/path 形式
+func (app *Application) URL(name string, params ...any) (string, error) {
+	routeMu.RLock()
+	defer routeMu.RUnlock()
+	if target := app.router.(*route).root().findName(RouteName(name)); target != nil {
+		return target.build(params)
+	}
+	domains := app.domains.Load()
+	if domains == nil {
+		return "", ErrNotFound.WithMessage("route not found: " + name)
+	}
+	for _, d := range *domains {
+		target := d.router.findName(RouteName(name))
+		if target == nil {
+			continue
+		}
+		res, err := target.build(params)
+		if err != nil || !d.host.exact() {
+			return res, err
+		}
+		return "//" + d.host.raw + res, nil
+	}
+	return "", ErrNotFound.WithMessage("route not found: " + name)
+}
+
+// NotFound 见 Router.NotFound, 作用于主路由
+func (app *Application) NotFound(handlers ...any) {
+	app.router.NotFound(handlers...)
+}
+
+// MethodNotAllowed 见 Router.MethodNotAllowed, 作用于主路由
+func (app *Application) MethodNotAllowed(handlers ...any) {
+	app.router.MethodNotAllowed(handlers...)
+}
+
+// PanicHandler 见 Router.PanicHandler, 作用于主路由
+func (app *Application) PanicHandler(fc FuncErr) {
+	app.router.PanicHandler(fc)
+}
+
 func (app *Application) Run() error {
 	logv.WithNoCaller.Info().Msg("listening " + app.config.Url())
 	l, e := app.netListener()
@@ -145,7 +252,9 @@ func (app *Application) EnableAI() {
 		x.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE, PATCH, PROPFIND")
 		x.Header().Set("Access-Control-Allow-Headers", "Accept, Content-Type, Content-Length, Accept-Encoding, X-CSRF-Token, Authorization, depth")
 		x.Header().Set("Access-Control-Expose-Headers", "Vyes-Root, Vyes-Vdev")
+		routeMu.RLock()
 		resp := r.getSchema()
+		routeMu.RUnlock()
 		err := x.JSON(resp)
 		if err != nil {
 			logv.WithNoCaller.Error().Msgf("ai txt encode error: %s", err)
@@ -0,0 +1,342 @@
+//
+// tree.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"net/http"
+	"slices"
+	"strings"
+)
+
+// 与 allowedMethods 顺序一致, 用于按下标取处理链
+const (
+	methodGet = iota
+	methodHead
+	methodPost
+	methodPut
+	methodPatch
+	methodDelete
+	methodConnect
+	methodOptions
+	methodTrace
+	methodPropfind
+	methodAny
+	methodCount
+)
+
+func methodIndex(m string) int {
+	switch m {
+	case http.MethodGet:
+		return methodGet
+	case http.MethodHead:
+		return methodHead
+	case http.MethodPost:
+		return methodPost
+	case http.MethodPut:
+		return methodPut
+	case http.MethodPatch:
+		return methodPatch
+	case http.MethodDelete:
+		return methodDelete
+	case http.MethodConnect:
+		return methodConnect
+	case http.MethodOptions:
+		return methodOptions
+	case http.MethodTrace:
+		return methodTrace
+	case "PROPFIND":
+		return methodPropfind
+	case "ANY":
+		return methodAny
+	}
+	return -1
+}
+
+// table 由 route 树编译出的只读匹配树, route 树变更后重新生成
+type table struct {
+	root *node
+	conf *RouterConf
+	// 单条路由最多的参数个数, 用于预分配 Params
+	maxParams int
+	// 合并了 Application 和内置编码器, 见 route.renderers
+	renderers []Renderer
+	app       *Application
+	// Extend 进来的子路由可能有自己的配置, 编译时按配置缓存
+	rendererCache map[*RouterConf][]Renderer
+}
+
+// entry 注册了处理函数的节点
+type entry struct {
+	route    *route
+	handlers [methodCount][]any
+	infos    [methodCount]*RouteInfo
+	slash    int8
+	// 所在子路由配置的编码器
+	renderers []Renderer
+	// 按版本从高到低排列, 见 Version
+	version  []int
+	versions []*entry
+}
+
+// resolve 返回处理 m 方法的下标, 未注册时回退到 ANY, 都没有时返回 -1
+func (e *entry) resolve(m int) int {
+	if m >= 0 && len(e.handlers[m]) > 0 {
+		return m
+	}
+	if len(e.handlers[methodAny]) > 0 {
+		return methodAny
+	}
+	return -1
+}
+
+// accepts 是否有任意版本处理 m 方法
+func (e *entry) accepts(m int) bool {
+	if e.resolve(m) >= 0 {
+		return true
+	}
+	for _, ve := range e.versions {
+		if ve.resolve(m) >= 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// pick 选择不高于 ver 的最新版本处理 m 方法, ver 为 nil 时选择最新版本
+// 未指定版本的处理函数视为最低版本
+func (e *entry) pick(m int, ver []int) (*entry, int) {
+	for _, ve := range e.versions {
+		if ver != nil && compareVersion(ve.version, ver) > 0 {
+			continue
+		}
+		if i := ve.resolve(m); i >= 0 {
+			return ve, i
+		}
+	}
+	return e, e.resolve(m)
+}
+
+// node 压缩前缀树节点
+// 静态部分按字符压缩, 参数和通配符只出现在片段开头
+type node struct {
+	path string
+	// 静态子节点的首字节, 与 children 一一对应
+	indices  string
+	children []*node
+	// 参数子节点, 顺序同 route.colons
+	params   []*node
+	wildcard *node
+	pattern  *segPattern
+	wildName string
+	entry    *entry
+	// 子树中 entry 的数量, 决定静态子节点的尝试顺序
+	priority int
+}
+
+func (r *route) getTable() *table {
+	if t := r.table.Load(); t != nil {
+		return t
+	}
+	routeMu.RLock()
+	defer routeMu.RUnlock()
+	t := r.compile()
+	r.table.Store(t)
+	return t
+}
+
+// invalidate 路由变更后清除自身及所有上级的匹配树
+func (r *route) invalidate() {
+	for tr := r; tr != nil; tr = tr.parent {
+		tr.table.Store(nil)
+	}
+}
+
+func (r *route) compile() *table {
+	t := &table{root: &node{}, conf: r.config(), rendererCache: make(map[*RouterConf][]Renderer)}
+	t.renderers = r.renderers(t.conf)
+	t.app = r.root().app
+	t.rendererCache[t.conf] = t.renderers
+	t.walk(r, t.root, "", true, 0)
+	t.rendererCache = nil
+	t.root.sortByPriority()
+	return t
+}
+
+func (t *table) newEntry(r *route) *entry {
+	e := &entry{route: r, slash: r.slash}
+	conf := r.config()
+	if e.renderers = t.rendererCache[conf]; e.renderers == nil {
+		e.renderers = r.renderers(conf)
+		t.rendererCache[conf] = e.renderers
+	}
+	for m, fcs := range r.handlers {
+		if i := methodIndex(m); i >= 0 && len(fcs) > 0 {
+			e.handlers[i] = r.handlersCache[m]
+			info := r.routeInfo(m)
+			e.infos[i] = &info
+		}
+	}
+	for _, vr := range r.sortedVersions() {
+		ve := t.newEntry(vr)
+		ve.version, _ = parseVersion(string(vr.version))
+		e.versions = append(e.versions, ve)
+	}
+	return e
+}
+
+// walk 将 r 子树插入匹配树, buf 为 cur 之后尚未插入的静态路径
+func (t *table) walk(r *route, cur *node, buf string, top bool, params int) {
+	t.maxParams = max(t.maxParams, params)
+	if r.registered() {
+		cur.insert(buf).entry = t.newEntry(r)
+	}
+	pre := buf
+	if !top {
+		pre += "/"
+	}
+	for frag, sub := range r.subRouters {
+		t.walk(sub, cur, pre+frag, false, params)
+	}
+	if len(r.colons) > 0 {
+		n := cur.insert(pre)
+		for _, c := range r.colons {
+			p := &node{pattern: c.pattern}
+			n.params = append(n.params, p)
+			t.walk(c, p, "", false, params+len(c.pattern.params))
+		}
+	}
+	if r.wildcard != nil {
+		n := cur.insert(pre)
+		n.wildcard = &node{wildName: r.wildcard.fragment[1:]}
+		if r.wildcard.registered() {
+			n.wildcard.entry = t.newEntry(r.wildcard)
+		}
+		t.maxParams = max(t.maxParams, params+1)
+	}
+}
+
+// insert 返回静态路径 s 末端的节点, 必要时拆分已有的边
+func (n *node) insert(s string) *node {
+	if s == "" {
+		return n
+	}
+	i := strings.IndexByte(n.indices, s[0])
+	if i < 0 {
+		child := &node{path: s}
+		n.indices += s[:1]
+		n.children = append(n.children, child)
+		return child
+	}
+	child := n.children[i]
+	l := 0
+	for l < len(s) && l < len(child.path) && s[l] == child.path[l] {
+		l++
+	}
+	if l < len(child.path) {
+		mid := &node{
+			path:     child.path[:l],
+			indices:  child.path[l : l+1],
+			children: []*node{child},
+		}
+		child.path = child.path[l:]
+		n.children[i] = mid
+		child = mid
+	}
+	return child.insert(s[l:])
+}
+
+func (n *node) sortByPriority() int {
+	n.priority = 0
+	if n.entry != nil {
+		n.priority++
+	}
+	for _, c := range n.children {
+		n.priority += c.sortByPriority()
+	}
+	for _, c := range n.params {
+		n.priority += c.sortByPriority()
+	}
+	if n.wildcard != nil {
+		n.priority += n.wildcard.sortByPriority()
+	}
+	slices.SortStableFunc(n.children, func(a, b *node) int {
+		return b.priority - a.priority
+	})
+	var sb strings.Builder
+	for _, c := range n.children {
+		sb.WriteByte(c.path[0])
+	}
+	n.indices = sb.String()
+	return n.priority
+}
+
+// lookup 匹配剩余路径, 路径参数先写入 x.Params, 回溯时截断
+func (n *node) lookup(path string, m int, x *X) *entry {
+	if path == "" || path == "/" {
+		if n.entry != nil && n.entry.accepts(m) {
+			return n.entry
+		}
+	}
+	c := byte('/')
+	if path != "" {
+		c = path[0]
+	}
+	for i := 0; i < len(n.indices); i++ {
+		if n.indices[i] != c {
+			continue
+		}
+		child := n.children[i]
+		if len(path) >= len(child.path) && path[:len(child.path)] == child.path {
+			if e := child.lookup(path[len(child.path):], m, x); e != nil {
+				return e
+			}
+		} else if len(path)+1 == len(child.path) && child.path[len(path)] == '/' && child.path[:len(path)] == path {
+			// 缺少末尾的 /, 如 /static 匹配 /static/*path
+			if e := child.lookup("", m, x); e != nil {
+				return e
+			}
+		}
+		break
+	}
+	if len(n.params) > 0 && path != "" {
+		end := strings.IndexByte(path, '/')
+		if end < 0 {
+			end = len(path)
+		}
+		l := len(x.Params)
+		for _, p := range n.params {
+			if !p.pattern.match(path[:end], &x.Params) {
+				continue
+			}
+			if e := p.lookup(path[end:], m, x); e != nil {
+				return e
+			}
+			x.Params = x.Params[:l]
+		}
+	}
+	if w := n.wildcard; w != nil && w.entry != nil && w.entry.accepts(m) {
+		x.setParam(w.wildName, path)
+		return w.entry
+	}
+	return nil
+}
+
+// matchHead 同 match, HEAD 没有匹配时回退到 GET, 返回实际使用的方法
+func (t *table) matchHead(path string, m int, x *X) (*entry, int) {
+	if e := t.match(path, m, x); e != nil || m != methodHead {
+		return e, m
+	}
+	return t.match(path, methodGet, x), methodGet
+}
+
+func (t *table) match(path string, m int, x *X) *entry {
+	if cap(x.Params)-len(x.Params) < t.maxParams {
+		x.Params = append(make(Params, 0, len(x.Params)+t.maxParams), x.Params...)
+	}
+	return t.root.lookup(path, m, x)
+}
@@ -10,6 +10,7 @@ package vigo
 import (
 	"net/http"
 	"reflect"
+	"time"
 )
 
 // map
@@ -29,6 +30,17 @@ type FuncAny2Err = func(*X, any) error
 type FuncAny2AnyErr = func(*X, any) (any, error)
 type FuncDescription = string
 
+// RouteName 路由名称, 作为 Set 的参数传入, 用于 Router.URL 反向生成路径
+type RouteName string
+
+// Meta 路由元数据, 作为 Set 的参数传入, 中间件通过 x.Route().Meta(key) 读取
+// 如 r.Get("/user", vigo.Meta{"perm": "user.read"}, handler)
+type Meta map[string]any
+
+// Timeout 路由超时时间, 作为 Set 的参数传入, 覆盖 RouterConf.Timeout, 小于 0 时不限制
+// 如 r.Get("/report", vigo.Timeout(time.Minute), handler)
+type Timeout time.Duration
+
 type FuncHttp2None = func(http.ResponseWriter, *http.Request)
 type FuncHttp2Any = func(http.ResponseWriter, *http.Request) any
 type FuncHttp2Err = func(http.ResponseWriter, *http.Request) error
@@ -152,3 +164,30 @@ func createStandardizedFunc(originalFn any, fnType reflect.Type) func(*X) (any,
 		return results[0].Interface(), nil
 	}
 }
+
+// typedFunc 由 Set, UseBefore, UseAfter 自动适配的 func(*X, T) (U, error), T 为结构体或结构体指针
+// 调用前通过 x.Parse 解析 T, 保留原函数名用于 RouteInfo 和日志
+type typedFunc struct {
+	fn   FuncX2AnyErr
+	name string
+	opts reflect.Type
+	resp reflect.Type
+}
+
+var errorType = reflect.TypeFor[error]()
+
+func adaptTyped(fc any) (*typedFunc, bool) {
+	t := reflect.TypeOf(fc)
+	if t == nil || t.Kind() != reflect.Func || t.NumIn() != 2 || t.NumOut() != 2 || t.IsVariadic() {
+		return nil, false
+	}
+	if t.In(0) != xType || !isStruct(t.In(1)) || t.Out(1) != errorType {
+		return nil, false
+	}
+	return &typedFunc{
+		fn:   createStandardizedFunc(fc, t),
+		name: funcName(fc),
+		opts: t.In(1),
+		resp: t.Out(0),
+	}, true
+}
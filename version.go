@@ -0,0 +1,175 @@
+//
+// version.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/vyes-ai/vigo/logv"
+)
+
+// Version API 版本, 作为 Set 的参数传入, 同一路径可以按版本注册不同的处理函数
+// 如 v1, v2, v2.1, 未指定版本的处理函数视为最低版本
+//
+//	r.Get("/user", getUser)
+//	r.Get("/user", vigo.Version("v2"), getUserV2)
+//
+// 请求的版本由 RouterConf.Versioning 决定, 选择不高于请求版本的最新版本, 未指定时使用最新版本
+type Version string
+
+// Versioning 从请求中读取版本的方式, 按以下顺序:
+// 路径前缀 /v2/user, 请求头 Accept-Version: v2, 媒体类型 Accept: application/vnd.myapp.v2+json
+// 只对带版本的路由生效, 版本号不合法时视为未指定
+type Versioning struct {
+	// 读取版本的请求头, 默认 Accept-Version
+	Header string
+	// 媒体类型中的厂商名, 如 myapp, 为空时接受任意厂商
+	Vendor string
+	// 支持 /v2/user 形式的路径前缀, 路径第一段为版本号且去掉后匹配到带版本的路由时使用
+	// 否则按原路径匹配, 如未带版本的 /v2/info 或 /v:n<\d+>/info
+	PathPrefix bool
+	// 已退役的版本, 响应时添加 Deprecation/Sunset/Link 头
+	Retired map[Version]Retirement
+}
+
+type Retirement struct {
+	// 弃用时间
+	Deprecation time.Time
+	// 停止服务时间
+	Sunset time.Time
+	// 迁移文档地址
+	Link string
+}
+
+// parseVersion 解析 v2.1 形式的版本号
+func parseVersion(s string) ([]int, bool) {
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "v"), "V")
+	if s == "" {
+		return nil, false
+	}
+	res := make([]int, 0, 3)
+	for _, p := range strings.Split(s, ".") {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return nil, false
+		}
+		res = append(res, n)
+	}
+	return res, true
+}
+
+func compareVersion(a, b []int) int {
+	for i := 0; i < max(len(a), len(b)); i++ {
+		var x, y int
+		if i < len(a) {
+			x = a[i]
+		}
+		if i < len(b) {
+			y = b[i]
+		}
+		if x != y {
+			return x - y
+		}
+	}
+	return 0
+}
+
+// versionNode 返回版本 v 的节点, 与 r 路径相同, 中间件沿 r 继承
+func (r *route) versionNode(v Version) *route {
+	_, ok := parseVersion(string(v))
+	logv.Assert(ok, "invalid version: "+string(v))
+	if r.versions == nil {
+		r.versions = make(map[Version]*route)
+	}
+	if vr := r.versions[v]; vr != nil {
+		return vr
+	}
+	vr := &route{parent: r, version: v}
+	r.versions[v] = vr
+	return vr
+}
+
+// registered 节点上是否注册了任意版本的处理函数
+func (r *route) registered() bool {
+	return len(r.handlers) > 0 || len(r.versions) > 0
+}
+
+// sortedVersions 按版本从高到低排列
+func (r *route) sortedVersions() []*route {
+	res := make([]*route, 0, len(r.versions))
+	for _, vr := range r.versions {
+		res = append(res, vr)
+	}
+	slices.SortFunc(res, func(a, b *route) int {
+		va, _ := parseVersion(string(a.version))
+		vb, _ := parseVersion(string(b.version))
+		return compareVersion(vb, va)
+	})
+	return res
+}
+
+// pathVersion 读取路径前缀中的版本, rest 为去掉版本前缀后的路径
+func (v *Versioning) pathVersion(path string) (ver []int, rest string, ok bool) {
+	if !v.PathPrefix {
+		return nil, path, false
+	}
+	seg, rest := nextSegment(path)
+	if ver, ok = parseVersion(seg); !ok || seg[0] != 'v' && seg[0] != 'V' {
+		return nil, path, false
+	}
+	return ver, rest, true
+}
+
+// requestVersion 从请求头读取版本
+func (v *Versioning) requestVersion(req *http.Request) []int {
+	if ver, ok := parseVersion(req.Header.Get(v.Header)); ok {
+		return ver
+	}
+	for _, accept := range strings.Split(req.Header.Get("Accept"), ",") {
+		mt, _, _ := strings.Cut(accept, ";")
+		_, vnd, ok := strings.Cut(strings.TrimSpace(mt), "/vnd.")
+		if !ok {
+			continue
+		}
+		vnd, _, _ = strings.Cut(vnd, "+")
+		if v.Vendor != "" {
+			if vnd, ok = strings.CutPrefix(vnd, v.Vendor+"."); !ok {
+				continue
+			}
+		} else if _, vnd, ok = strings.Cut(vnd, "."); !ok {
+			continue
+		}
+		if ver, ok := parseVersion(vnd); ok {
+			return ver
+		}
+	}
+	return nil
+}
+
+// retire 为退役版本添加响应头
+func (v *Versioning) retire(x *X, ver Version) {
+	info, ok := v.Retired[ver]
+	if !ok {
+		return
+	}
+	if !info.Deprecation.IsZero() {
+		x.Header().Set("Deprecation", "@"+strconv.FormatInt(info.Deprecation.Unix(), 10))
+	} else {
+		x.Header().Set("Deprecation", "true")
+	}
+	if !info.Sunset.IsZero() {
+		x.Header().Set("Sunset", info.Sunset.UTC().Format(http.TimeFormat))
+	}
+	if info.Link != "" {
+		x.Header().Add("Link", "<"+info.Link+`>; rel="deprecation"`)
+	}
+}
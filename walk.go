@@ -0,0 +1,153 @@
+//
+// walk.go
+// Copyright (C) 2025 veypi <i@veypi.com>
+//
+// Distributed under terms of the MIT license.
+//
+
+package vigo
+
+import (
+	"reflect"
+	"runtime"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// RouteInfo 已注册路由的结构化信息, 由 Router.Walk 返回
+type RouteInfo struct {
+	// 完整路径, 包含 Extend/SubRouter 添加的前缀, 如 /user/:id<int>
+	Path   string
+	Method string
+	// Set 时传入的 Version, 未指定时为空
+	Version Version
+	Name    RouteName
+	// 路径参数名, 从根到叶依次排列, 通配符不含 *
+	Params []string
+	Desc   string
+	// Set 时传入的参数结构体类型, 或 func(*X, T) (U, error) 处理函数的 T, 未传入时为 nil
+	Args reflect.Type
+	// func(*X, T) (U, error) 处理函数的 U, 其它处理函数为 nil
+	Resp reflect.Type
+	// Set 时传入的处理函数
+	Handlers []string
+	// 完整处理链, 包含继承的 before/after 中间件
+	Chain []string
+	// 调用 Set 的位置
+	File   string
+	Line   int
+	Caller string
+	// Set 时传入的 Meta, 多个时合并
+	Metadata Meta
+	// Set 时传入的 Timeout, 为 0 时使用 RouterConf.Timeout
+	Timeout time.Duration
+}
+
+// Meta 读取路由元数据, 不存在时返回 nil
+func (ri *RouteInfo) Meta(key string) any {
+	if ri == nil {
+		return nil
+	}
+	return ri.Metadata[key]
+}
+
+// Walk 按路径顺序遍历 r 及其子路由上注册的所有路由, fn 返回错误时停止遍历并返回该错误
+// fn 中可以继续注册路由, 新路由不会出现在本次遍历中
+func (r *route) Walk(fn func(RouteInfo) error) error {
+	routeMu.RLock()
+	infos := r.routeInfos(nil)
+	routeMu.RUnlock()
+	for _, info := range infos {
+		if err := fn(info); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *route) routeInfos(res []RouteInfo) []RouteInfo {
+	for _, m := range allowedMethods {
+		if len(r.handlers[m]) > 0 {
+			res = append(res, r.routeInfo(m))
+		}
+	}
+	for _, vr := range r.sortedVersions() {
+		res = vr.routeInfos(res)
+	}
+	keys := make([]string, 0, len(r.subRouters))
+	for k := range r.subRouters {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+	for _, k := range keys {
+		res = r.subRouters[k].routeInfos(res)
+	}
+	for _, c := range r.colons {
+		res = c.routeInfos(res)
+	}
+	if r.wildcard != nil {
+		res = r.wildcard.routeInfos(res)
+	}
+	return res
+}
+
+func (r *route) routeInfo(m string) RouteInfo {
+	info := RouteInfo{
+		Path:    r.String(),
+		Method:  m,
+		Version: r.version,
+		Name:    r.handlersName[m],
+		Params:  r.paramNames(),
+		Desc:    r.handlersDesc[m],
+		Args:    r.handlersArgs[m],
+		Resp:    r.handlersResp[m],
+		// 共享注册时的 Meta, 请求期间只读
+		Metadata: r.handlersMeta[m],
+		Timeout:  r.handlersTime[m],
+	}
+	slash := r.slash
+	if r.version != "" {
+		slash = r.parent.slash
+	}
+	if info.Path == "" {
+		info.Path = "/"
+	} else if slash == 2 {
+		info.Path += "/"
+	}
+	for _, h := range r.handlers[m] {
+		info.Handlers = append(info.Handlers, funcName(h))
+	}
+	for _, h := range r.handlersCache[m] {
+		info.Chain = append(info.Chain, funcName(h))
+	}
+	if caller, ok := r.handlersCaller[m]; ok {
+		info.File = caller[0]
+		info.Line, _ = strconv.Atoi(caller[1])
+		info.Caller = caller[2]
+	}
+	return info
+}
+
+func (r *route) paramNames() []string {
+	res := make([]string, 0, 4)
+	for tr := r; tr != nil; tr = tr.parent {
+		if tr.pattern != nil {
+			names := tr.pattern.names()
+			slices.Reverse(names)
+			res = append(res, names...)
+		} else if strings.HasPrefix(tr.fragment, "*") {
+			res = append(res, tr.fragment[1:])
+		}
+	}
+	slices.Reverse(res)
+	return res
+}
+
+func funcName(h any) string {
+	if tf, ok := h.(*typedFunc); ok {
+		return tf.name
+	}
+	return runtime.FuncForPC(reflect.ValueOf(h).Pointer()).Name()
+}
@@ -9,14 +9,12 @@ package vigo
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"net"
 	"net/http"
-	"reflect"
-	"runtime"
 	"runtime/debug"
+	"slices"
 	"strconv"
-	"strings"
 	"sync"
 
 	"github.com/vyes-ai/vigo/logv"
@@ -25,11 +23,20 @@ import (
 const version = "v0.5.2"
 
 type X struct {
-	writer  http.ResponseWriter
+	writer  respWriter
 	Request *http.Request
 	Params  Params
 	fcs     []any
 	fid     int
+	route   *route
+	info    *RouteInfo
+	store   *store
+	ctx     *storeCtx
+	err     error
+	// OnFinish 注册的函数
+	finishes  []func(*X)
+	renderers []Renderer
+	app       *Application
 }
 
 var _ http.ResponseWriter = &X{}
@@ -57,16 +64,50 @@ func (x *X) Next(args ...any) {
 			} else {
 				err = fmt.Errorf("%s: %v", ErrCrash, e)
 			}
+			x.err = err
 			if ve, ok := err.(*Error); ok {
 				// 有特别明确需求取调用panic(vigo.Error)不打印堆栈
 				logv.WithNoCaller.Warn().Msgf("panic: %s, code: %d", ve.Message, ve.Code)
 			} else {
 				logv.WithNoCaller.Error().Msgf("%s", debug.Stack())
 			}
+			if x.route != nil {
+				if fc := x.route.panicHandler(); fc != nil {
+					if err = fc(x, err); err == nil {
+						return
+					}
+				}
+			}
+			if cerr := x.ctxErr(); cerr != nil {
+				x.handleCtxErr(cerr)
+				return
+			}
 			x.handleErr(err)
 		}
 	}()
 	if x.fid >= len(x.fcs) {
+		if cerr := x.ctxErr(); cerr != nil {
+			// 超时后不再输出返回值
+			x.handleCtxErr(cerr)
+			return
+		}
+		if x.fid == len(x.fcs) && len(args) > 0 && args[0] != nil && !x.Written() {
+			// 最后一个处理函数的返回值, 字符串和数值同 x.JSON 直接输出, 其它按 Accept 编码
+			if err := x.renderResult(args[0]); err != nil {
+				logv.WithNoCaller.Warn().Msgf("render %T: %v", args[0], err)
+				if !x.Written() {
+					code := http.StatusInternalServerError
+					if e, ok := err.(*Error); ok && e.Code < 600 {
+						code = e.Code
+					}
+					x.WriteHeader(code)
+				}
+			}
+		}
+		return
+	}
+	if err := x.ctxErr(); err != nil {
+		x.handleCtxErr(err)
 		return
 	}
 	fc := x.fcs[x.fid]
@@ -93,28 +134,44 @@ func (x *X) Next(args ...any) {
 	case FuncAny2AnyErr:
 		response, err = fc(x, arg)
 	case FuncHttp2None:
-		fc(x.ResponseWriter(), x.Request)
+		fc(x.ResponseWriter(), x.httpRequest())
 	case FuncHttp2Any:
-		response = fc(x.ResponseWriter(), x.Request)
+		response = fc(x.ResponseWriter(), x.httpRequest())
 	case FuncHttp2Err:
-		err = fc(x.ResponseWriter(), x.Request)
+		err = fc(x.ResponseWriter(), x.httpRequest())
 	case FuncHttp2AnyErr:
-		response, err = fc(x.ResponseWriter(), x.Request)
+		response, err = fc(x.ResponseWriter(), x.httpRequest())
+	case *typedFunc:
+		response, err = fc.fn(x)
 	case FuncErr:
-		// do nothing
+		// 没有错误时跳过, 上一个处理函数的返回值继续传递
+		response = arg
 	case FuncDescription:
 	default:
 		logv.Warn().Msgf("unknown func type %T", fc)
 	}
 	if err != nil {
-		logv.WithNoCaller.Info().Msgf("%s return error: %v", runtime.FuncForPC(reflect.ValueOf(fc).Pointer()).Name(), err)
+		logv.WithNoCaller.Info().Msgf("%s return error: %v", funcName(fc), err)
+		if cerr := x.ctxErr(); cerr != nil {
+			x.handleCtxErr(cerr)
+			return
+		}
 		x.handleErr(err)
 		return
 	}
 	x.Next(response)
 }
 
+// handleCtxErr 处理 ctxErr 返回的错误, 错误处理函数没有写入响应时只写入状态码, 同 hookFallback
+func (x *X) handleCtxErr(err *Error) {
+	x.handleErr(err)
+	if !x.Written() {
+		x.WriteHeader(err.Code)
+	}
+}
+
 func (x *X) handleErr(err error) bool {
+	x.err = err
 	if x.fid >= len(x.fcs) {
 		logv.Warn().Msgf("unhandled error: %v", err)
 		return false
@@ -127,59 +184,125 @@ func (x *X) handleErr(err error) bool {
 			if err == nil {
 				return true
 			}
+			x.err = err
 		}
 	}
 	logv.Warn().Msgf("unhandled error: %v", err)
 	return false
 }
 
+// Err 处理链中最后出现的错误, 包括 panic, 被 FuncErr 处理后仍然保留
+// 没有错误时返回 nil, 通常在 OnFinish 中使用
+func (x *X) Err() error {
+	return x.err
+}
+
+// OnFinish 注册在整个处理链结束后执行的函数, 包括 panic 被恢复之后
+// 按注册的相反顺序执行, 每个函数的 panic 单独恢复, 不影响其它函数
+//
+//	tx := db.Begin()
+//	x.OnFinish(func(x *vigo.X) {
+//		if x.Err() != nil {
+//			tx.Rollback()
+//		}
+//	})
+func (x *X) OnFinish(fc func(*X)) {
+	x.finishes = append(x.finishes, fc)
+}
+
+func (x *X) finish() {
+	for len(x.finishes) > 0 {
+		fc := x.finishes[len(x.finishes)-1]
+		x.finishes = x.finishes[:len(x.finishes)-1]
+		x.runFinish(fc)
+	}
+}
+
+func (x *X) runFinish(fc func(*X)) {
+	defer func() {
+		if e := recover(); e != nil {
+			logv.WithNoCaller.Error().Msgf("on finish panic: %v\n%s", e, debug.Stack())
+		}
+	}()
+	fc(x)
+}
+
+// ctxErr 请求超时或客户端断开时返回对应的错误, 之后只有错误处理函数可以写入响应
+func (x *X) ctxErr() *Error {
+	ctx := x.writer.ctx
+	if ctx == nil || ctx.Err() == nil {
+		return nil
+	}
+	x.writer.ctx = nil
+	if errors.Is(ctx.Err(), context.Canceled) {
+		return ErrCanceled
+	}
+	if e, ok := context.Cause(ctx).(*Error); ok {
+		return e
+	}
+	return ErrTimeout
+}
+
 func (x *X) ResponseWriter() http.ResponseWriter {
-	return x.writer
+	return &x.writer
 }
 
+// Get 读取 Set 写入的值, 找不到时从 Request.Context() 中查找
+// 推荐使用类型化的 Key, 见 NewKey
 func (x *X) Get(key string) any {
-	return x.Request.Context().Value(key)
+	v, _ := x.value(key)
+	return v
 }
 
+// Set 写入当前请求的存储, 可以通过 x.Get 和 x.Context() 读取
+//
+// 注意: 与旧版本不同, Set 不再复制 x.Request, x.Request.Context() 中读取不到写入的值
+// 传给下游库时使用 x.Context(), 而不是 x.Request.Context()
 func (x *X) Set(key string, value any) {
-	if x.Request == nil {
-		logv.Warn().Msgf("set %s=%v to nil request", key, value)
-		return
-	}
-	x.Request = x.Request.WithContext(context.WithValue(x.Request.Context(), key, value))
+	x.setValue(key, value)
 }
 
+// Context 返回请求的 context, 包含此时 Set 和 Key.Set 写入的值, 可以传给下游库并在其它 goroutine 中读取
+// 之后写入的值需要重新调用 Context 获取
 func (x *X) Context() context.Context {
-	return x.Request.Context()
+	if x.store == nil {
+		return x.Request.Context()
+	}
+	if x.ctx == nil || x.ctx.Context != x.Request.Context() {
+		x.ctx = &storeCtx{Context: x.Request.Context(), s: store{kvs: slices.Clone(x.store.kvs)}}
+	}
+	return x.ctx
 }
 
-func (x *X) GetRemoteIP() string {
-	// 首先尝试从 X-Forwarded-For 获取 IP 地址
-	ip := x.Request.Header.Get("X-Forwarded-For")
-	if ip != "" {
-		// X-Forwarded-For 可能包含多个 IP 地址，以逗号分隔，
-		// 这里我们取第一个 IP 地址作为客户端的 IP。
-		return strings.TrimSpace(strings.Split(ip, ",")[0])
-	}
+// Route 返回当前匹配的路由信息, 未匹配路由时 (如 NotFound 处理链中) 返回 nil
+func (x *X) Route() *RouteInfo {
+	return x.info
+}
 
-	// 如果 X-Forwarded-For 不存在，则尝试从 X-Real-IP 获取 IP 地址
-	ip = x.Request.Header.Get("X-Real-IP")
-	if ip != "" {
-		return ip
+// URLFor 根据路由名称生成路径, 见 Router.URL
+// 当前路由树中找不到时继续在 Application 的 Domain 路由中查找
+func (x *X) URLFor(name string, params ...any) (string, error) {
+	if x.route == nil {
+		return "", ErrNotFound.WithMessage("route not found: " + name)
 	}
-
-	// 如果以上两个都没有，则直接从 RemoteAddr 获取 IP 地址
-	ip, _, err := net.SplitHostPort(x.Request.RemoteAddr)
-	if err != nil {
-		return ""
+	routeMu.RLock()
+	root := x.route.root()
+	if target := root.findName(RouteName(name)); target != nil {
+		defer routeMu.RUnlock()
+		return target.build(params)
+	}
+	app := root.app
+	routeMu.RUnlock()
+	if app != nil {
+		return app.URL(name, params...)
 	}
-	return ip
+	return "", ErrNotFound.WithMessage("route not found: " + name)
 }
 
 func (x *X) setParam(k string, v string) {
-	for _, p := range x.Params {
-		if p[0] == k {
-			p[1] = v
+	for i := range x.Params {
+		if x.Params[i][0] == k {
+			x.Params[i][1] = v
 			return
 		}
 	}
@@ -225,7 +348,16 @@ func release(x *X) {
 	x.fid = 0
 	x.Params = x.Params[0:0]
 	x.Request = nil
-	x.writer = nil
+	x.writer.reset(nil)
 	x.fcs = nil
+	x.route = nil
+	x.info = nil
+	x.store = nil
+	x.ctx = nil
+	x.err = nil
+	x.renderers = nil
+	x.app = nil
+	clear(x.finishes[:cap(x.finishes)])
+	x.finishes = x.finishes[:0]
 	xPool.Put(x)
 }
@@ -25,7 +25,7 @@ var parserErr = NewError("parse arg %s with error: %s").WithCode(http.StatusConf
 
 // Parse 从 HTTP 请求中解析参数到目标结构体
 // 从不同来源解析目标结构体一级字段
-// tag标签 parse:"path/header/query/form/json" 可以追加为 path@alias_name
+// tag标签 parse:"path/header/query/form/json/cookie" 可以追加为 path@alias_name
 // tag标签 default:"" 对指针类和json类字段无效
 
 func (x *X) Parse(target any) error {
@@ -170,6 +170,12 @@ func (x *X) Parse(target any) error {
 			}
 		case strings.HasPrefix(parseTag, "path"):
 			value, found = x.Params.Try(fieldName)
+		case parseTag == "cookie":
+			// 原始值, 签名和加密的 cookie 使用 x.SignedCookie 和 x.SecureCookie 读取
+			if c, err := x.Request.Cookie(fieldName); err == nil {
+				value = c.Value
+				found = true
+			}
 		}
 
 		// 设置字段值
@@ -8,17 +8,136 @@
 package vigo
 
 import (
+	"bufio"
+	"context"
+	"crypto/sha256"
 	"embed"
+	"encoding/hex"
 	"encoding/json"
 	"fmt"
 	"io"
 	"mime"
+	"net"
 	"net/http"
 	"os"
 	"path/filepath"
-	"strconv"
+	"sync"
+	"time"
+
+	"github.com/vyes-ai/vigo/logv"
 )
 
+// respWriter 记录响应状态, x.ResponseWriter() 返回的就是它
+// 保留底层的 http.Flusher, http.Hijacker 和 io.ReaderFrom, 不支持时 Hijack 返回 http.ErrNotSupported
+type respWriter struct {
+	http.ResponseWriter
+	status int
+	size   int64
+	// 已发送响应头
+	written bool
+	// HEAD 请求回退到 GET 处理时丢弃响应体
+	discard bool
+	// 请求的 context, 结束后拒绝写入, 避免超时后处理函数继续写入
+	ctx context.Context
+}
+
+// stale 请求已超时或被取消
+func (w *respWriter) stale() bool {
+	return w.ctx != nil && w.ctx.Err() != nil
+}
+
+var (
+	_ http.Flusher  = &respWriter{}
+	_ http.Hijacker = &respWriter{}
+	_ io.ReaderFrom = &respWriter{}
+)
+
+func (w *respWriter) reset(rw http.ResponseWriter) {
+	*w = respWriter{ResponseWriter: rw}
+}
+
+func (w *respWriter) WriteHeader(code int) {
+	if w.stale() {
+		return
+	}
+	if w.written {
+		// 避免 superfluous WriteHeader, 以第一次写入的状态码为准
+		logv.Debug().Msgf("ignore WriteHeader(%d), status %d already sent", code, w.status)
+		return
+	}
+	w.ResponseWriter.WriteHeader(code)
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		// 1xx 信息响应之后还可以写入最终状态码
+		return
+	}
+	w.status = code
+	w.written = true
+}
+
+func (w *respWriter) Write(p []byte) (int, error) {
+	if w.stale() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return len(p), nil
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *respWriter) ReadFrom(r io.Reader) (int64, error) {
+	if w.stale() {
+		return 0, http.ErrHandlerTimeout
+	}
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.discard {
+		return io.Copy(io.Discard, r)
+	}
+	var n int64
+	var err error
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		// 隐藏 ReadFrom, 避免 io.Copy 递归调用
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.size += n
+	return n, err
+}
+
+func (w *respWriter) Flush() {
+	if !w.written {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *respWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, http.ErrNotSupported
+	}
+	conn, rw, err := h.Hijack()
+	if err == nil && !w.written {
+		w.status = http.StatusSwitchingProtocols
+		w.written = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap 供 http.ResponseController 使用
+func (w *respWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
 func (x *X) Header() http.Header {
 	return x.writer.Header()
 }
@@ -31,80 +150,175 @@ func (x *X) Write(p []byte) (n int, err error) {
 	return x.writer.Write(p)
 }
 
+// Status 已发送的状态码, 未发送时返回 200, 即处理结束时默认发送的状态码
+func (x *X) Status() int {
+	if x.writer.status == 0 {
+		return http.StatusOK
+	}
+	return x.writer.status
+}
+
+// BytesWritten 已写入的响应体字节数
+func (x *X) BytesWritten() int64 {
+	return x.writer.size
+}
+
+// Written 是否已发送响应头, 之后修改 Header 和状态码不再生效
+func (x *X) Written() bool {
+	return x.writer.written
+}
+
+// JSON 输出 data, 字符串和数值直接写入, 未设置 Content-Type 时为 text/plain, 其它类型编码为 JSON
+// data 为 *Result 时先写入其响应头和状态码
 func (x *X) JSON(data any) error {
-	var err error
+	res, ok := data.(*Result)
+	if ok {
+		data = res.Data
+	}
+	b, raw := rawBytes(data)
+	if !raw && data != nil {
+		var err error
+		if b, err = json.Marshal(data); err != nil {
+			return err
+		}
+		x.Header().Set("Content-Type", "application/json")
+	}
+	if b != nil && x.Header().Get("Content-Type") == "" {
+		x.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	}
+	if ok {
+		res.writeHeader(x)
+	}
+	if data == nil {
+		return nil
+	}
+	_, err := x.writer.Write(b)
+	return err
+}
+
+// rawBytes 字符串, 数值和错误不经过编码直接输出, 见 X.JSON
+func rawBytes(data any) ([]byte, bool) {
 	switch v := data.(type) {
 	case string:
-		_, err = x.writer.Write([]byte(v))
+		return []byte(v), true
 	case []byte:
-		_, err = x.writer.Write(v)
+		return v, true
 	case error:
-		_, err = x.writer.Write([]byte(v.Error()))
-	case nil:
+		return []byte(v.Error()), true
 	case int, uint, int8, uint8, int16, uint16, int32, uint32, int64, uint64, float32, float64, bool:
-		_, err = x.writer.Write((fmt.Appendf([]byte{}, "%v", v)))
-	default:
-		b, err := json.Marshal(data)
-		if err != nil {
-			return err
-		}
-		x.Header().Add("Content-Type", "application/json")
-		_, err = x.Write(b)
+		return fmt.Appendf([]byte{}, "%v", v), true
 	}
-	return err
+	return nil, false
 }
 
+// Embed 输出嵌入的文件, 支持 Range, If-None-Match 等条件请求
+// 嵌入文件没有修改时间, ETag 为内容的哈希, 每个文件只计算一次
 func (x *X) Embed(fs *embed.FS, fpath string) error {
 	file, err := fs.Open(fpath)
 	if err != nil {
 		return err
 	}
+	defer file.Close()
 	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
-	contentType := mime.TypeByExtension(filepath.Ext(fpath))
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	content, ok := file.(io.ReadSeeker)
+	if !ok || info.IsDir() {
+		return ErrNotFound.WithMessage("not a file: " + fpath)
+	}
+	key := embedKey{fs, fpath}
+	etag, ok := embedETags.Load(key)
+	if !ok {
+		h := sha256.New()
+		if _, err := io.Copy(h, content); err != nil {
+			return err
+		}
+		if _, err := content.Seek(0, io.SeekStart); err != nil {
+			return err
+		}
+		etag, _ = embedETags.LoadOrStore(key, `"`+hex.EncodeToString(h.Sum(nil)[:16])+`"`)
 	}
-	x.Header().Set("Content-Type", contentType)
-	x.Header().Set("Content-Length", strconv.FormatInt(info.Size(), 10))
-	io.Copy(x, file)
-	return nil
+	return x.serveContent(fpath, info.ModTime(), etag.(string), content)
+}
+
+type embedKey struct {
+	fs   *embed.FS
+	path string
 }
 
+var embedETags sync.Map
+
+// File 输出本地文件, 支持 Range, If-None-Match, If-Modified-Since 等条件请求
+// ETag 由文件大小和修改时间生成
 func (x *X) File(path string) error {
-	// 打开文件
 	file, err := os.Open(path)
 	if err != nil {
 		return err
 	}
 	defer file.Close()
-	fileInfo, err := file.Stat()
+	info, err := file.Stat()
 	if err != nil {
 		return err
 	}
-	contentType := mime.TypeByExtension(filepath.Ext(path))
-	if contentType == "" {
-		contentType = "application/octet-stream"
+	if info.IsDir() {
+		return ErrNotFound.WithMessage("not a file: " + path)
+	}
+	etag := fmt.Sprintf(`"%x-%x"`, info.ModTime().UnixNano(), info.Size())
+	return x.serveContent(path, info.ModTime(), etag, file)
+}
+
+// serveContent 由 http.ServeContent 处理 Range 和条件请求, 返回复制内容时的错误
+func (x *X) serveContent(name string, modtime time.Time, etag string, content io.ReadSeeker) error {
+	h := x.Header()
+	if h.Get("Content-Type") == "" {
+		contentType := mime.TypeByExtension(filepath.Ext(name))
+		if contentType == "" {
+			contentType = "application/octet-stream"
+		}
+		h.Set("Content-Type", contentType)
+	}
+	if h.Get("ETag") == "" {
+		h.Set("ETag", etag)
 	}
-	x.Header().Set("Content-Type", contentType)
-	x.Header().Set("Content-Length", strconv.FormatInt(fileInfo.Size(), 10))
-	io.Copy(x, file)
-	return nil
+	w := &serveWriter{respWriter: &x.writer}
+	http.ServeContent(w, x.Request, name, modtime, content)
+	return w.err
+}
+
+// serveWriter 记录写入错误, http.ServeContent 本身不返回错误
+// 保留 ReadFrom, 本地文件仍然可以使用 sendfile
+type serveWriter struct {
+	*respWriter
+	err error
+}
+
+func (w *serveWriter) Write(p []byte) (int, error) {
+	n, err := w.respWriter.Write(p)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+func (w *serveWriter) ReadFrom(r io.Reader) (int64, error) {
+	n, err := w.respWriter.ReadFrom(r)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
 }
 
 func (x *X) SSEWriter() func(p []byte) (int, error) {
 	x.writer.Header().Set("Content-Type", "text/event-stream")
 	x.writer.Header().Set("Cache-Control", "no-cache")
 	x.writer.Header().Set("Connection", "keep-alive")
-	f := x.writer.(http.Flusher)
 	fc := func(p []byte) (int, error) {
 		l, err := x.writer.Write(p)
 		if err != nil {
 			return l, err
 		}
-		f.Flush()
+		x.writer.Flush()
 		return l, nil
 	}
 	return fc
@@ -116,27 +330,26 @@ func (x *X) SSEEvent() func(string, any) (int, error) {
 	x.writer.Header().Set("Connection", "keep-alive")
 	return func(event string, data any) (n int, err error) {
 		if event != "" && event != "data" {
-			if nn, err := fmt.Fprintf(x.writer, "event: %s\n", event); err != nil {
+			if nn, err := fmt.Fprintf(&x.writer, "event: %s\n", event); err != nil {
 				return nn, err
 			} else {
 				n = n + nn
 			}
 		}
 		if data != nil {
-			if nn, err := fmt.Fprintf(x.writer, "data: %s\n\n", data); err != nil {
+			if nn, err := fmt.Fprintf(&x.writer, "data: %s\n\n", data); err != nil {
 				return nn + n, err
 			} else {
 				n = n + nn
 			}
 		} else {
-			if nn, err := fmt.Fprint(x.writer, "\n"); err != nil {
+			if nn, err := fmt.Fprint(&x.writer, "\n"); err != nil {
 				return nn + n, err
 			} else {
 				n = n + nn
 			}
 		}
-		f := x.writer.(http.Flusher)
-		f.Flush()
+		x.writer.Flush()
 		return n, err
 	}
 }